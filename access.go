@@ -0,0 +1,94 @@
+package hnoss
+
+import "strings"
+
+// checkAccess returns why ev is denied by the access lists of c, or "" if it's allowed. Any match of a deny list
+// denies ev. If any allow lists are set, ev must match at least one of them, so allowing a guild and a user allows
+// everyone in the guild and the user anywhere. Channel IDs are matched as seen by Hnoss, prefixed with the adapter
+// name if ChatAdapters is set, e.g. "discord:1234", and so are user and role IDs, as by isAdmin.
+func (c *Config) checkAccess(ev *ChatEvent) string {
+	user, roles := c.authorIDs(ev)
+	switch {
+	case contains(c.AccessDenyUsers, user):
+		return "user denied"
+	case containsAny(c.AccessDenyRoles, roles):
+		return "role denied"
+	case ev.GuildID != "" && contains(c.AccessDenyGuilds, ev.GuildID):
+		return "guild denied"
+	case contains(c.AccessDenyChannels, ev.ChanID):
+		return "channel denied"
+	}
+	if len(c.AccessAllowUsers)+len(c.AccessAllowRoles)+len(c.AccessAllowGuilds)+len(c.AccessAllowChannels) > 0 &&
+		!contains(c.AccessAllowUsers, user) &&
+		!containsAny(c.AccessAllowRoles, roles) &&
+		!(ev.GuildID != "" && contains(c.AccessAllowGuilds, ev.GuildID)) &&
+		!contains(c.AccessAllowChannels, ev.ChanID) {
+		return "not allowed"
+	}
+	if c.AccessDMOnly && ev.ReplyDirect == nil {
+		return "can't reply by direct message"
+	}
+	return ""
+}
+
+// isAdmin returns whether the author of ev may change subscriptions, by user or role. If ChatAdapters is set, user and
+// role IDs are matched prefixed with the name of the adapter of ev, as its channel ID is, e.g. "discord:1234", so an ID
+// on one chat service doesn't make an administrator of whoever has it on another.
+func (c *Config) isAdmin(ev *ChatEvent) bool {
+	user, roles := c.authorIDs(ev)
+	return contains(c.AdminUsers, user) || containsAny(c.AdminRoles, roles)
+}
+
+// authorIDs returns the user and role IDs of the author of ev, prefixed with the name of its adapter if ChatAdapters
+// is set.
+func (c *Config) authorIDs(ev *ChatEvent) (string, []string) {
+	user, roles := ev.AuthorID, ev.RoleIDs
+	if len(c.ChatAdapters) > 0 {
+		name, _, _ := strings.Cut(ev.ChanID, ":")
+		if user != "" {
+			user = name + ":" + user
+		}
+		roles = make([]string, len(ev.RoleIDs))
+		for i, r := range ev.RoleIDs {
+			roles[i] = name + ":" + r
+		}
+	}
+	return user, roles
+}
+
+// directEvent returns a copy of ev that replies privately to its author, posting notice if ev needs a response.
+func directEvent(ev *ChatEvent, notice string) *ChatEvent {
+	d := *ev
+	d.Reply = ev.ReplyDirect
+	if ev.DirectNotice != nil {
+		d.Reply = func(msg string) error {
+			if err := ev.ReplyDirect(msg); err != nil {
+				return err
+			}
+			return ev.DirectNotice(notice)
+		}
+	}
+	d.AnnounceReply = nil
+	return &d
+}
+
+func contains(list []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(list, ss []string) bool {
+	for _, s := range ss {
+		if contains(list, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package hnoss
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAccess(t *testing.T) {
+	direct := func(string) error { return nil }
+	ev := func(user, guild, channel string, roles ...string) *ChatEvent {
+		return &ChatEvent{AuthorID: user, GuildID: guild, ChanID: channel, RoleIDs: roles, ReplyDirect: direct}
+	}
+	testCases := []struct {
+		description string
+		conf        Config
+		ev          *ChatEvent
+		xReason     string
+	}{
+		{"Open", Config{}, ev("u", "g", "c"), ""},
+		{"DenyUser", Config{AccessDenyUsers: []string{"u"}}, ev("u", "g", "c"), "user denied"},
+		{"DenyRole", Config{AccessDenyRoles: []string{"r2"}}, ev("u", "g", "c", "r1", "r2"), "role denied"},
+		{"DenyGuild", Config{AccessDenyGuilds: []string{"g"}}, ev("u", "g", "c"), "guild denied"},
+		{"DenyChannel", Config{AccessDenyChannels: []string{"c"}}, ev("u", "g", "c"), "channel denied"},
+		{"DenyWins", Config{AccessAllowUsers: []string{"u"}, AccessDenyGuilds: []string{"g"}}, ev("u", "g", "c"),
+			"guild denied"},
+		{"AllowGuild", Config{AccessAllowGuilds: []string{"g"}}, ev("u", "g", "c"), ""},
+		{"AllowGuildOther", Config{AccessAllowGuilds: []string{"g"}}, ev("u", "h", "c"), "not allowed"},
+		{"AllowGuildDM", Config{AccessAllowGuilds: []string{"g"}}, ev("u", "", "c"), "not allowed"},
+		{"AllowUserAnywhere", Config{AccessAllowGuilds: []string{"g"}, AccessAllowUsers: []string{"u"}},
+			ev("u", "", "c"), ""},
+		{"AllowRole", Config{AccessAllowRoles: []string{"r2"}}, ev("u", "g", "c", "r1", "r2"), ""},
+		{"AllowChannel", Config{AccessAllowChannels: []string{"discord:c"}}, ev("u", "g", "discord:c"), ""},
+		{"DMOnly", Config{AccessDMOnly: true}, ev("u", "g", "c"), ""},
+		{"DMOnlyUnsupported", Config{AccessDMOnly: true}, &ChatEvent{AuthorID: "u"}, "can't reply by direct message"},
+		{"ScopedUser", Config{ChatAdapters: []string{"discord", "irc"}, AccessDenyUsers: []string{"discord:u"}},
+			ev("u", "g", "discord:c"), "user denied"},
+		{"ScopedUserOther", Config{ChatAdapters: []string{"discord", "irc"}, AccessDenyUsers: []string{"discord:u"}},
+			ev("u", "", "irc:#c"), ""},
+		{"ScopedRole", Config{ChatAdapters: []string{"discord", "irc"}, AccessAllowRoles: []string{"discord:r"}},
+			ev("u", "g", "discord:c", "r"), ""},
+		{"ScopedRoleUnscoped", Config{ChatAdapters: []string{"discord", "irc"}, AccessAllowRoles: []string{"r"}},
+			ev("u", "g", "discord:c", "r"), "not allowed"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.xReason, tc.conf.checkAccess(tc.ev))
+		})
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	conf := Config{AdminUsers: []string{"u", "discord:u"}, AdminRoles: []string{"discord:r"}}
+	assert.True(t, conf.isAdmin(&ChatEvent{ChanID: "c", AuthorID: "u"}))
+	assert.False(t, conf.isAdmin(&ChatEvent{ChanID: "c", AuthorID: "v", RoleIDs: []string{"r"}}))
+
+	// With several chat adapters, IDs are scoped to the adapter of the event.
+	conf.ChatAdapters = []string{"discord", "irc"}
+	assert.True(t, conf.isAdmin(&ChatEvent{ChanID: "discord:c", AuthorID: "u"}))
+	assert.False(t, conf.isAdmin(&ChatEvent{ChanID: "irc:#c", AuthorID: "u"}))
+	assert.True(t, conf.isAdmin(&ChatEvent{ChanID: "discord:c", AuthorID: "v", RoleIDs: []string{"r"}}))
+	assert.False(t, conf.isAdmin(&ChatEvent{ChanID: "irc:#c", AuthorID: "v", RoleIDs: []string{"r"}}))
+}
+
+func TestCommandAccess(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	chat := &mockChatAdaptor{}
+	conf := &Config{AccessDenyUsers: []string{"bad"}, AccessRefusal: "no", AccessDMOnly: true}
+	h := New(conf, logger, nil, nil, nil, &mockHistoryAdaptor{}, nil, nil, chat, nil)
+
+	var replies, direct []string
+	dismissed := 0
+	ev := func(user string) *ChatEvent {
+		return &ChatEvent{
+			ChanID:   "1234",
+			AuthorID: user,
+			Command:  historyCommand,
+			Reply: func(msg string) error {
+				replies = append(replies, msg)
+				return nil
+			},
+			ReplyDirect: func(msg string) error {
+				direct = append(direct, msg)
+				return nil
+			},
+			Dismiss: func() error {
+				dismissed++
+				return nil
+			},
+		}
+	}
+	notice := func(user string) *ChatEvent {
+		e := ev(user)
+		e.DirectNotice = e.Reply
+		return e
+	}
+	h.command(ev("bad"))
+	assert.Equal(t, []string{"no"}, replies)
+	assert.Empty(t, direct)
+
+	h.command(ev("good"))
+	assert.Equal(t, []string{"no"}, replies)
+	assert.Equal(t, []string{"no ip address changes recorded"}, direct)
+
+	assert.Equal(t, 0, dismissed)
+
+	// Where a response is needed, the reply is noticed.
+	h.command(notice("good"))
+	assert.Equal(t, []string{"no", "replied by direct message"}, replies)
+	assert.Len(t, direct, 2)
+	replies = replies[:1]
+
+	// Without a refusal denied requests are ignored, any pending response withdrawn.
+	conf.AccessRefusal = ""
+	h.command(ev("bad"))
+	assert.Equal(t, []string{"no"}, replies)
+	assert.Equal(t, "", chat.postMsg)
+	assert.Equal(t, 1, dismissed)
+}
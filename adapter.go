@@ -2,14 +2,23 @@ package hnoss
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/netip"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 )
 
+// httpTimeout limits the HTTP requests of the chat and announcement adapters, longer than their long polls.
+const httpTimeout = time.Minute
+
+// httpClient is shared by the adapters that talk HTTP, so no request can hang forever.
+var httpClient = &http.Client{Timeout: httpTimeout}
+
 type (
 	TextFileTimeAdapter struct {
 		file string
@@ -20,6 +29,12 @@ type (
 	PlainTextIPServiceAdapter struct {
 		url string
 	}
+	TextFileHistoryAdapter struct {
+		file string
+	}
+	JSONFileSubscriptionAdapter struct {
+		file string
+	}
 	RealNowAdapter struct{}
 )
 
@@ -124,6 +139,110 @@ func readIP(file io.Reader, desc string) (ip netip.Addr, err error) {
 	return
 }
 
+func NewTextFileHistoryAdapter(file string) *TextFileHistoryAdapter {
+	return &TextFileHistoryAdapter{
+		file: file,
+	}
+}
+
+// Append writes c to the end of the history file as a line of tab separated fields.
+func (m *TextFileHistoryAdapter) Append(c *Change) (err error) {
+	file, closeFile := appendFile(m.file, "history", &err)
+	if err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(file, "%s\t%s\t%s\t%s\n", c.Time.Format(time.RFC3339), addrString(c.Old),
+		addrString(c.New), c.Source)
+	if err != nil {
+		err = ErrorWrap(err, "failed to write to history file")
+	}
+	closeFile()
+	return
+}
+
+func (m *TextFileHistoryAdapter) List(n int) ([]*Change, error) {
+	b, err := os.ReadFile(m.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, ErrorWrapf(err, "failed to read history file: %s", m.file)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	var changes []*Change
+	for i := len(lines) - 1; i >= 0 && len(changes) < n; i-- {
+		if lines[i] == "" {
+			continue
+		}
+		c, err := parseChange(lines[i])
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+func parseChange(line string) (c *Change, err error) {
+	fields := strings.SplitN(line, "\t", 4)
+	if len(fields) != 4 {
+		return nil, Errorf("malformed history line: %s", line)
+	}
+	c = &Change{Source: fields[3]}
+	if c.Time, err = time.Parse(time.RFC3339, fields[0]); err != nil {
+		return nil, ErrorWrapf(err, "failed to parse date from history line: %s", line)
+	}
+	if c.Old, err = parseAddrString(fields[1]); err != nil {
+		return nil, ErrorWrapf(err, "failed to parse old IP address from history line: %s", line)
+	}
+	if c.New, err = parseAddrString(fields[2]); err != nil {
+		return nil, ErrorWrapf(err, "failed to parse new IP address from history line: %s", line)
+	}
+	return
+}
+
+// addrString formats ip, using "-" for the zero Addr.
+func addrString(ip netip.Addr) string {
+	if !ip.IsValid() {
+		return "-"
+	}
+	return ip.String()
+}
+
+func parseAddrString(s string) (netip.Addr, error) {
+	if s == "-" {
+		return netip.Addr{}, nil
+	}
+	return netip.ParseAddr(s)
+}
+
+func NewJSONFileSubscriptionAdapter(file string) *JSONFileSubscriptionAdapter {
+	return &JSONFileSubscriptionAdapter{
+		file: file,
+	}
+}
+
+// Get returns the subscriptions in the subscription file, none if it doesn't exist.
+func (m *JSONFileSubscriptionAdapter) Get() ([]*Subscription, error) {
+	s, err := readStringFile(m.file, "subscription")
+	if err != nil || s == "" {
+		return nil, err
+	}
+	var subs []*Subscription
+	if err = json.Unmarshal([]byte(s), &subs); err != nil {
+		return nil, ErrorWrapf(err, "failed to parse subscription file: %s", m.file)
+	}
+	return subs, nil
+}
+
+func (m *JSONFileSubscriptionAdapter) Put(subs []*Subscription) error {
+	b, err := json.Marshal(subs)
+	if err != nil {
+		return ErrorWrap(err, "failed to encode subscriptions")
+	}
+	return writeStringFile(m.file, "subscription", string(b))
+}
+
 func NewRealNowAdapter() *RealNowAdapter {
 	return &RealNowAdapter{}
 }
@@ -74,6 +74,35 @@ func TestTextFileIPAdapter(t *testing.T) {
 	assert.Equal(t, ip, ip2)
 }
 
+func TestTextFileHistoryAdapter(t *testing.T) {
+	require.NoError(t, os.RemoveAll("run/history"))
+	m := NewTextFileHistoryAdapter("run/history")
+	changes, err := m.List(10)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	ti, err := time.Parse(time.RFC3339, "2023-11-28T00:00:00Z")
+	require.NoError(t, err)
+	first := &Change{Time: ti, New: netip.MustParseAddr("1.2.3.4"), Source: "TextFileIPAdapter"}
+	second := &Change{Time: ti.Add(time.Hour), Old: first.New, New: netip.MustParseAddr("::1"),
+		Source: "PlainTextIPServiceAdapter"}
+	assert.NoError(t, m.Append(first))
+	assert.NoError(t, m.Append(second))
+
+	changes, err = m.List(10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Change{second, first}, changes)
+
+	changes, err = m.List(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Change{second}, changes)
+
+	// Capacity isn't allocated for changes that aren't there.
+	changes, err = m.List(1 << 60)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 2)
+}
+
 func TestPlainTextIPServiceAdapter(t *testing.T) {
 	server := serve()
 
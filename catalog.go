@@ -0,0 +1,289 @@
+package hnoss
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Catalog maps message IDs to the text of a locale, fmt formats where the message has arguments. Messages missing from
+// a catalog fall back to the default English text.
+type Catalog map[string]string
+
+// defaultLocale is the locale of defaultCatalog.
+const defaultLocale = "en"
+
+const (
+	msgError             = "error"
+	msgStatus            = "status"
+	msgHealthOK          = "healthOK"
+	msgHealthFailing     = "healthFailing"
+	msgQueued            = "queued"
+	msgHistoryEmpty      = "historyEmpty"
+	msgHistory           = "history"
+	msgUptime            = "uptime"
+	msgUnknownCommand    = "unknownCommand"
+	msgHelp              = "help"
+	msgHelpUnknown       = "helpUnknown"
+	msgNotAdmin          = "notAdmin"
+	msgSubscribed        = "subscribed"
+	msgUnsubscribed      = "unsubscribed"
+	msgNotSubscribed     = "notSubscribed"
+	msgSubscribeInvalid  = "subscribeInvalid"
+	msgFormatSet         = "formatSet"
+	msgFormatCleared     = "formatCleared"
+	msgFormatInvalid     = "formatInvalid"
+	msgSubscriptionsNone = "subscriptionsNone"
+	msgSubscriptions     = "subscriptions"
+	msgDigest            = "digest"
+	msgDigestNone        = "digestNone"
+	msgRepliedDirect     = "repliedDirect"
+	msgDiscordStatus     = "discordStatus"
+	msgDiscordFailed     = "discordFailed"
+	msgEmbedTitle        = "embedTitle"
+	msgEmbedNewIP        = "embedNewIP"
+	msgEmbedOldIP        = "embedOldIP"
+	msgEmbedPorts        = "embedPorts"
+	// msgAccessRefusal overrides Config.AccessRefusal for a locale, it has no default.
+	msgAccessRefusal = "accessRefusal"
+	// msgIPMessage overrides Config.IPMessageFormat for a locale, it has no default.
+	msgIPMessage = "ipMessage"
+	// msgHelpPrefix is prefixed to a command name to describe it, defaulting to Command.Description.
+	msgHelpPrefix = "help."
+	// msgEmbedTitlePrefix is prefixed to an AnnouncementKind for the title of its Discord embeds, defaulting to
+	// msgEmbedTitle.
+	msgEmbedTitlePrefix = "embedTitle."
+	// msgCommandPrefix is prefixed to a command name, and its option names, for the descriptions of Discord
+	// application commands.
+	msgCommandPrefix = "command."
+	// msgTemplatePrefix is prefixed to an AnnouncementKind for its message template in a locale, overriding
+	// Config.MessageTemplates.
+	msgTemplatePrefix = "template."
+)
+
+var defaultCatalog = Catalog{
+	msgError:             "failed to get ip address: %s",
+	msgStatus:            "ip address: %s\nlast run: %s\nnext run: %s",
+	msgHealthOK:          "health: ok",
+	msgHealthFailing:     "health: failing: %s",
+	msgQueued:            "queued announcements: %d",
+	msgHistoryEmpty:      "no ip address changes recorded",
+	msgHistory:           "ip address history:",
+	msgUptime:            "up %s since %s",
+	msgUnknownCommand:    "I don't know how to %s, try %s",
+	msgHelp:              "commands:",
+	msgHelpUnknown:       "unknown command: %s",
+	msgNotAdmin:          "sorry, only administrators can change subscriptions",
+	msgSubscribed:        "this channel is subscribed to: %s",
+	msgUnsubscribed:      "this channel is unsubscribed",
+	msgNotSubscribed:     "this channel isn't subscribed, try %s",
+	msgSubscribeInvalid:  "unknown event or address family: %s",
+	msgFormatSet:         "message template set",
+	msgFormatCleared:     "message template cleared",
+	msgFormatInvalid:     "couldn't set message template: %s",
+	msgSubscriptionsNone: "no channels are subscribed",
+	msgSubscriptions:     "subscriptions:",
+	msgDigest:            "ip address: %s\nchanges in the last day:",
+	msgDigestNone:        "ip address: %s\nno changes in the last day",
+	msgRepliedDirect:     "replied by direct message",
+	msgDiscordStatus:     "ip address: %s\nlast changed: %s\nlast checked: %s",
+	msgDiscordFailed:     "last check failed: %s",
+	msgEmbedTitle:        "hnoss",
+	msgEmbedNewIP:        "New IP",
+	msgEmbedOldIP:        "Old IP",
+	msgEmbedPorts:        "Ports",
+
+	msgEmbedTitlePrefix + string(ChangeAnnouncement):    "IP address changed",
+	msgEmbedTitlePrefix + string(ReplyAnnouncement):     "IP address",
+	msgEmbedTitlePrefix + string(ErrorAnnouncement):     "IP address check failed",
+	msgEmbedTitlePrefix + string(RecoveredAnnouncement): "IP address check recovered",
+	msgEmbedTitlePrefix + string(StartupAnnouncement):   "hnoss started",
+	msgEmbedTitlePrefix + string(ShutdownAnnouncement):  "hnoss stopped",
+
+	msgCommandPrefix + ipCommand:                "Show the IP address",
+	msgCommandPrefix + statusCommand:            "Show the IP address and when it was last and will next be checked",
+	msgCommandPrefix + refreshCommand:           "Check the IP address now",
+	msgCommandPrefix + historyCommand:           "Show recent IP address changes",
+	msgCommandPrefix + historyCommand + ".n":    "Number of changes to show",
+	msgCommandPrefix + uptimeCommand:            "Show how long the bot has been running",
+	msgCommandPrefix + helpCommand:              "Show what the bot can do",
+	msgCommandPrefix + helpCommand + ".command": "Command to describe",
+	msgCommandPrefix + subscribeCommand:         "Subscribe this channel to events",
+	msgCommandPrefix + subscribeCommand + ".events": "Any of change, error, recovered, startup, shutdown, digest, " +
+		"ipv4 and ipv6",
+	msgCommandPrefix + unsubscribeCommand:          "Unsubscribe this channel",
+	msgCommandPrefix + formatCommand:               "Set the message template of this channel",
+	msgCommandPrefix + formatCommand + ".template": "Message template, none to clear",
+	msgCommandPrefix + subscriptionsCommand:        "List subscribed channels",
+}
+
+// text returns message id of c, falling back to the default English text.
+func (c Catalog) text(id string) string {
+	if s, ok := c[id]; ok {
+		return s
+	}
+	return defaultCatalog[id]
+}
+
+// loadCatalogs reads a catalog from each <locale>.yaml file in dir, always including the default English catalog.
+// Messages must have the same fmt verbs, in the same order, as the English text, so they're formatted with the same
+// arguments.
+func loadCatalogs(dir string) (map[string]Catalog, error) {
+	catalogs := map[string]Catalog{defaultLocale: defaultCatalog}
+	if dir == "" {
+		return catalogs, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, ErrorWrapf(err, "config: failed to list locale directory: %s", dir)
+	}
+	for _, path := range paths {
+		locale := strings.TrimSuffix(filepath.Base(path), ".yaml")
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, ErrorWrapf(err, "config: failed to read locale file: %s", path)
+		}
+		var c Catalog
+		if err = yaml.Unmarshal(b, &c); err != nil {
+			return nil, ErrorWrapf(err, "config: failed to parse locale file: %s", path)
+		}
+		for id, s := range c {
+			verbs, ok := catalogVerbs(id)
+			if !ok {
+				return nil, Errorf("config: unknown message in locale file: %s: %s", path, id)
+			}
+			if v := formatVerbs(s); !strings.HasPrefix(id, msgTemplatePrefix) && v != verbs {
+				return nil, Errorf("config: message formatted with %q instead of %q in locale file: %s: %s",
+					v, verbs, path, id)
+			}
+		}
+		catalogs[locale] = c
+	}
+	return catalogs, nil
+}
+
+// catalogVerbs returns the fmt verbs of message id, see formatVerbs, false if there's no such message.
+func catalogVerbs(id string) (string, bool) {
+	if s, ok := defaultCatalog[id]; ok {
+		return formatVerbs(s), true
+	}
+	switch {
+	case id == msgAccessRefusal, strings.HasPrefix(id, msgHelpPrefix):
+		return "", true
+	case id == msgIPMessage:
+		return "s", true
+	case strings.HasPrefix(id, msgTemplatePrefix):
+		return "", containsKind(messageKinds, AnnouncementKind(strings.TrimPrefix(id, msgTemplatePrefix)))
+	}
+	return "", false
+}
+
+// formatVerbs returns the verbs of the fmt format s, in order, without their flags, width or precision, e.g. "sd" for
+// "%s: %5d%%".
+func formatVerbs(s string) string {
+	var verbs []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		for i++; i < len(s) && strings.IndexByte("+-# 0123456789.*[]", s[i]) >= 0; i++ {
+		}
+		if i < len(s) && s[i] != '%' {
+			verbs = append(verbs, s[i])
+		}
+	}
+	return string(verbs)
+}
+
+// parseLocaleTemplates parses the message templates of each catalog, keyed by locale then AnnouncementKind.
+func parseLocaleTemplates(catalogs map[string]Catalog) (map[string]map[AnnouncementKind]*template.Template, error) {
+	var templates map[string]map[AnnouncementKind]*template.Template
+	for locale, c := range catalogs {
+		for id, text := range c {
+			name, ok := strings.CutPrefix(id, msgTemplatePrefix)
+			if !ok {
+				continue
+			}
+			tmpl, err := parseMessageTemplate(locale+" "+name, text, AnnouncementKind(name))
+			if err != nil {
+				return nil, prefixError(err, "config")
+			}
+			if templates == nil {
+				templates = make(map[string]map[AnnouncementKind]*template.Template)
+			}
+			if templates[locale] == nil {
+				templates[locale] = make(map[AnnouncementKind]*template.Template)
+			}
+			templates[locale][AnnouncementKind(name)] = tmpl
+		}
+	}
+	return templates, nil
+}
+
+// checkLocales returns an error if any locale configured isn't in c.Catalogs.
+func (c *Config) checkLocales() error {
+	check := func(locale, desc string) error {
+		if _, ok := c.Catalogs[locale]; !ok {
+			return Errorf("config: no catalog for %s locale: %s", desc, locale)
+		}
+		return nil
+	}
+	if err := check(c.Locale, "default"); err != nil {
+		return err
+	}
+	for id, locale := range c.LocaleChannels {
+		if err := check(locale, "channel "+id); err != nil {
+			return err
+		}
+	}
+	for id, locale := range c.LocaleGuilds {
+		if err := check(locale, "guild "+id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// locale returns the locale of ev, from its channel, then its guild, falling back to the default, which is also the
+// locale of announcements, with a nil ev.
+func (h *Hnoss) locale(ev *ChatEvent) string {
+	if h.config == nil {
+		return defaultLocale
+	}
+	if ev != nil {
+		if l, ok := h.config.LocaleChannels[ev.ChanID]; ok {
+			return l
+		}
+		if l, ok := h.config.LocaleGuilds[ev.GuildID]; ok && ev.GuildID != "" {
+			return l
+		}
+	}
+	if h.config.Locale == "" {
+		return defaultLocale
+	}
+	return h.config.Locale
+}
+
+// lookup returns the text of message id in the locale of ev, false if neither that locale nor the default has it.
+func (h *Hnoss) lookup(ev *ChatEvent, id string) (string, bool) {
+	if h.config != nil {
+		if s, ok := h.config.Catalogs[h.locale(ev)][id]; ok {
+			return s, true
+		}
+	}
+	s, ok := defaultCatalog[id]
+	return s, ok
+}
+
+// tr returns message id in the locale of ev, formatted with args.
+func (h *Hnoss) tr(ev *ChatEvent, id string, args ...any) string {
+	s, _ := h.lookup(ev, id)
+	if len(args) == 0 {
+		return s
+	}
+	return fmt.Sprintf(s, args...)
+}
@@ -0,0 +1,135 @@
+package hnoss
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCatalogs(t *testing.T) {
+	catalogs, err := loadCatalogs("locales")
+	require.NoError(t, err)
+	assert.Equal(t, defaultCatalog, catalogs["en"])
+	assert.Equal(t, "kommandoer:", catalogs["nb"][msgHelp])
+
+	dir := t.TempDir()
+	load := func(yaml string) error {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "xx.yaml"), []byte(yaml), 0644))
+		_, err := loadCatalogs(dir)
+		return err
+	}
+	assert.EqualError(t, load("hello: hei\n"),
+		"ERROR: config: unknown message in locale file: "+filepath.Join(dir, "xx.yaml")+": hello")
+	assert.EqualError(t, load("template.hello: hei\n"),
+		"ERROR: config: unknown message in locale file: "+filepath.Join(dir, "xx.yaml")+": template.hello")
+	// Translations must format the same arguments as the original.
+	assert.EqualError(t, load("uptime: \"oppe i %d siden %s\"\n"), "ERROR: config: message formatted with \"ds\" "+
+		"instead of \"ss\" in locale file: "+filepath.Join(dir, "xx.yaml")+": uptime")
+	assert.Error(t, load("queued: \"kunngjøringer i kø\"\n"))
+	assert.Error(t, load("ipMessage: \"IP %d\"\n"))
+	assert.NoError(t, load("queued: \"100%% i kø: %3d\"\nipMessage: \"IP: %s\"\n"))
+	require.NoError(t, load("template.change: \"{{.Nothing}}\"\n"))
+	catalogs, err = loadCatalogs(dir)
+	require.NoError(t, err)
+	_, err = parseLocaleTemplates(catalogs)
+	assert.ErrorContains(t, err, "config: invalid xx change message template")
+
+	conf := &Config{Locale: "nb", Catalogs: map[string]Catalog{"en": defaultCatalog}}
+	assert.EqualError(t, conf.checkLocales(), "ERROR: config: no catalog for default locale: nb")
+	conf = &Config{Locale: "en", LocaleChannels: map[string]string{"c": "nb"},
+		Catalogs: map[string]Catalog{"en": defaultCatalog}}
+	assert.EqualError(t, conf.checkLocales(), "ERROR: config: no catalog for channel c locale: nb")
+}
+
+func TestFormatVerbs(t *testing.T) {
+	assert.Equal(t, "", formatVerbs("100%% done"))
+	assert.Equal(t, "sd", formatVerbs("%s: %5d%%"))
+	assert.Equal(t, "vq", formatVerbs("%+v %-10.2q"))
+	assert.Equal(t, "ss", formatVerbs("%[2]s %[1]s"))
+}
+
+func TestLocales(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	catalogs, err := loadCatalogs("locales")
+	require.NoError(t, err)
+	conf := &Config{
+		Locale:          "en",
+		LocaleChannels:  map[string]string{"english": "en"},
+		LocaleGuilds:    map[string]string{"norsk": "nb"},
+		Catalogs:        catalogs,
+		AccessDenyUsers: []string{"bad"},
+		AccessRefusal:   "no",
+		AnnounceErrors:  true,
+	}
+	announcer := &mockAnnouncerChatAdaptor{}
+	h := New(conf, logger, &mockTimeAdaptor{}, &mockIPAdaptor{err: NewError("An error")}, &mockIPAdaptor{},
+		&mockHistoryAdaptor{}, nil, nil, announcer, nil)
+
+	var reply string
+	request := func(chanID, guildID, user, text string) string {
+		ev := &ChatEvent{ChanID: chanID, GuildID: guildID, AuthorID: user, Reply: func(msg string) error {
+			reply = msg
+			return nil
+		}}
+		ev.Command, ev.Args = ParseCommand(text)
+		h.command(ev)
+		return reply
+	}
+	assert.Equal(t, "ingen endringer av IP-adressen registrert", request("c", "norsk", "u", "history"))
+	// A channel's locale takes precedence over its guild's.
+	assert.Equal(t, "no ip address changes recorded", request("english", "norsk", "u", "history"))
+	assert.Equal(t, "no ip address changes recorded", request("c", "", "u", "history"))
+	assert.Equal(t, "kommandoer:\nuptime: Vis hvor lenge boten har kjørt", request("c", "norsk", "u", "help uptime"))
+	assert.Equal(t, "beklager, du har ikke lov til å spørre meg om det", request("c", "norsk", "bad", "ip"))
+	assert.Equal(t, "no", request("c", "", "bad", "ip"))
+
+	// Announcements use the default locale.
+	conf.Locale = "nb"
+	h.run(newTime(t, "2023-11-28T14:00:00Z"), false, nil)
+	assert.Equal(t, "klarte ikke å hente IP-adressen: An error", announcer.announcement.Message)
+}
+
+func TestLocaleMessages(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	catalogs := map[string]Catalog{
+		"en": defaultCatalog,
+		"nb": {msgTemplatePrefix + "change": "endret fra {{.OldIP}} til {{.IP}}", msgIPMessage: "IP-adresse: %s"},
+	}
+	templates, err := parseLocaleTemplates(catalogs)
+	require.NoError(t, err)
+	conf := &Config{
+		Locale:          "en",
+		LocaleChannels:  map[string]string{"norsk": "nb"},
+		Catalogs:        catalogs,
+		LocaleTemplates: templates,
+		IPMessageFormat: "%s:2456",
+	}
+	announcer := &mockAnnouncerChatAdaptor{}
+	h := New(conf, logger, &mockTimeAdaptor{}, &mockIPAdaptor{ip: newIP(t, "5.6.7.8")}, &mockIPAdaptor{},
+		&mockHistoryAdaptor{}, nil, nil, announcer, &mockNowAdaptor{now: newTime(t, "2023-11-28T14:00:00Z")})
+	h.ip = newIP(t, "1.2.3.4")
+
+	var reply string
+	request := func(chanID string) string {
+		ev := &ChatEvent{ChanID: chanID, Command: ipCommand, Reply: func(msg string) error {
+			reply = msg
+			return nil
+		}}
+		h.command(ev)
+		return reply
+	}
+	// Replies use the locale's IP message, templates its template, over the configured ones.
+	assert.Equal(t, "1.2.3.4:2456", request("c"))
+	assert.Equal(t, "IP-adresse: 1.2.3.4", request("norsk"))
+	h.run(newTime(t, "2023-11-28T14:00:00Z"), false, nil)
+	assert.Equal(t, "5.6.7.8:2456", announcer.announcement.Message)
+	conf.Locale = "nb"
+	h.ip = newIP(t, "1.2.3.4")
+	h.run(newTime(t, "2023-11-28T15:00:00Z"), false, nil)
+	assert.Equal(t, "endret fra 1.2.3.4 til 5.6.7.8", announcer.announcement.Message)
+}
@@ -0,0 +1,131 @@
+package hnoss
+
+// NewChatAdapter returns the ChatAdapter selected by conf.ChatAdapter, or a MultiChatAdapter of those listed in
+// conf.ChatAdapters, if any, each behind an Outbox if conf.OutboxFile is set. Adapters that report events outside of
+// their method calls log them to logger.
+func NewChatAdapter(conf *Config, logger *Logger) (ChatAdapter, error) {
+	if len(conf.ChatAdapters) == 0 {
+		a, err := newChatAdapter(conf.ChatAdapter, conf, logger)
+		if err != nil {
+			return nil, err
+		}
+		return newOutbox(a, conf, conf.OutboxFile, logger), nil
+	}
+	adapters := make(map[string]ChatAdapter, len(conf.ChatAdapters))
+	for _, name := range conf.ChatAdapters {
+		if _, ok := adapters[name]; ok {
+			return nil, Fatalf("config: duplicate chat adapter: %s", name)
+		}
+		a, err := newChatAdapter(name, conf, logger)
+		if err != nil {
+			return nil, err
+		}
+		// Each adapter has its own outbox, so one that's down doesn't hold up the others.
+		adapters[name] = newOutbox(a, conf, conf.OutboxFile+"-"+name, logger)
+	}
+	return NewMultiChatAdapter(logger, adapters), nil
+}
+
+// newOutbox returns a queueing announcements to file, if conf.OutboxFile is set.
+func newOutbox(a ChatAdapter, conf *Config, file string, logger *Logger) ChatAdapter {
+	if conf.OutboxFile == "" {
+		return a
+	}
+	return NewOutbox(a, logger, OutboxOptions{File: file, TTL: conf.OutboxTTL})
+}
+
+func newChatAdapter(name string, conf *Config, logger *Logger) (ChatAdapter, error) {
+	switch name {
+	case "discord":
+		return NewDiscordChatAdapter(conf.DiscordBotToken, conf.DiscordDefaultChannelName, DiscordOptions{
+			SlashCommands:   conf.DiscordSlashCommands,
+			Ephemeral:       conf.DiscordEphemeral,
+			ChannelIDs:      conf.DiscordChannelIDs,
+			GuildChannels:   conf.DiscordGuildChannels,
+			PinStatus:       conf.DiscordPinStatus,
+			StatusFile:      conf.DiscordStatusFile,
+			StatusTopic:     conf.DiscordStatusTopic,
+			Embeds:          conf.DiscordEmbeds,
+			EmbedTitles:     conf.DiscordEmbedTitles,
+			EmbedColors:     conf.DiscordEmbedColors,
+			EmbedPorts:      discordEmbedPorts(conf),
+			EmbedFooter:     conf.DiscordEmbedFooter,
+			Presence:        conf.DiscordPresence,
+			PresenceFormat:  conf.DiscordPresenceFormat,
+			Catalog:         conf.Catalogs[conf.Locale],
+			Catalogs:        conf.Catalogs,
+			ReadyTimeout:    conf.DiscordReadyTimeout,
+			ConnectAttempts: conf.DiscordConnectAttempts,
+			Logger:          logger,
+		}), nil
+	case "discord-webhook":
+		return NewDiscordWebhookChatAdapter(conf.DiscordWebhookURL, DiscordWebhookOptions{
+			Username:     conf.DiscordWebhookUsername,
+			AvatarURL:    conf.DiscordWebhookAvatarURL,
+			EditPrevious: conf.DiscordWebhookEdit,
+			MessageFile:  conf.DiscordWebhookMessageFile,
+		})
+	case "slack":
+		return NewSlackChatAdapter(conf.SlackAppToken, conf.SlackBotToken, conf.SlackDefaultChannel), nil
+	case "matrix":
+		return NewMatrixChatAdapter(conf.MatrixHomeserverURL, conf.MatrixAccessToken, conf.MatrixDefaultRoomAlias,
+			conf.MatrixSyncFile), nil
+	case "irc":
+		return NewIRCChatAdapter(conf.IRCServer, conf.IRCNick, conf.IRCDefaultChannel, IRCOptions{
+			TLS:             conf.IRCTLS,
+			SASLUser:        conf.IRCSASLUser,
+			SASLPassword:    conf.IRCSASLPassword,
+			PrivateMessages: conf.IRCPrivateMessages,
+		}), nil
+	case "telegram":
+		return NewTelegramChatAdapter(conf.TelegramBotToken, conf.TelegramDefaultChatID, TelegramOptions{
+			WebhookURL:    conf.TelegramWebhookURL,
+			WebhookListen: conf.TelegramWebhookListen,
+			WebhookSecret: conf.TelegramWebhookSecret,
+		}), nil
+	case "webhook":
+		return NewWebhookChatAdapter(conf.WebhookURL, WebhookOptions{
+			Method:       conf.WebhookMethod,
+			Headers:      conf.WebhookHeaders,
+			BodyTemplate: conf.WebhookBodyTemplate,
+			Secret:       conf.WebhookSecret,
+			Retries:      conf.WebhookRetries,
+		})
+	case "email":
+		return NewEmailChatAdapter(conf.EmailServer, conf.EmailFrom, conf.EmailTo, EmailOptions{
+			Security:        conf.EmailSecurity,
+			Auth:            conf.EmailAuth,
+			Username:        conf.EmailUsername,
+			Password:        conf.EmailPassword,
+			SubjectTemplate: conf.EmailSubjectTemplate,
+			BodyTemplate:    conf.EmailBodyTemplate,
+		})
+	case "ntfy", "gotify":
+		return NewPushChatAdapter(name, conf.PushURL, PushOptions{
+			Topic:      conf.PushTopic,
+			Token:      conf.PushToken,
+			Title:      conf.PushTitle,
+			Priorities: conf.PushPriorities,
+			Tags:       conf.PushTags,
+			ClickURL:   conf.PushClickURL,
+		})
+	case "mqtt":
+		return NewMQTTChatAdapter(conf.MQTTBroker, MQTTOptions{
+			ClientID:        conf.MQTTClientID,
+			Username:        conf.MQTTUsername,
+			Password:        conf.MQTTPassword,
+			Topic:           conf.MQTTTopic,
+			DiscoveryPrefix: conf.MQTTDiscoveryPrefix,
+		})
+	default:
+		return nil, Fatalf("config: unknown chat adapter: %s", name)
+	}
+}
+
+// discordEmbedPorts returns the ports shown in Discord embeds, conf.Ports unless overridden.
+func discordEmbedPorts(conf *Config) []int {
+	if len(conf.DiscordEmbedPorts) > 0 {
+		return conf.DiscordEmbedPorts
+	}
+	return conf.Ports
+}
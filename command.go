@@ -0,0 +1,127 @@
+package hnoss
+
+import (
+	"strings"
+	"time"
+)
+
+type (
+	// Command is a chat command, the first word of a request after any bot mention.
+	Command struct {
+		Name string
+		// Usage describes the arguments, if any, e.g. "[n]".
+		Usage       string
+		Description string
+		Handler     CommandHandler
+	}
+	// CommandHandler responds to ev, usually with Hnoss.Reply. An error is logged.
+	CommandHandler func(h *Hnoss, ev *ChatEvent) error
+)
+
+const (
+	uptimeCommand = "uptime"
+	helpCommand   = "help"
+)
+
+// builtinCommands are registered by New, in the order help lists them.
+var builtinCommands = []*Command{
+	{Name: ipCommand, Description: "Show the IP address", Handler: (*Hnoss).replyIP},
+	{Name: refreshCommand, Description: "Check the IP address now", Handler: func(h *Hnoss, ev *ChatEvent) error {
+		h.run(h.nowAdapter.Now(), false, ev)
+		return nil
+	}},
+	{Name: statusCommand, Description: "Show the IP address, when it was last and will next be checked, and whether " +
+		"checking is working", Handler: (*Hnoss).status},
+	{Name: historyCommand, Usage: "[n]", Description: "Show recent IP address changes", Handler: (*Hnoss).history},
+	{Name: uptimeCommand, Description: "Show how long the bot has been running", Handler: (*Hnoss).uptime},
+	{Name: helpCommand, Usage: "[command]", Description: "Show what the bot can do", Handler: (*Hnoss).help},
+}
+
+// RegisterCommand adds a command, which may not replace one already registered, including the built-in ip, refresh,
+// status, history, uptime and help commands.
+func (h *Hnoss) RegisterCommand(c *Command) error {
+	if c.Name == "" || c.Handler == nil {
+		return NewError("command must have a name and a handler")
+	}
+	name := strings.ToLower(c.Name)
+	if _, ok := h.commands[name]; ok {
+		return Errorf("command already registered: %s", name)
+	}
+	h.commands[name] = c
+	h.commandNames = append(h.commandNames, name)
+	return nil
+}
+
+// dispatch calls the handler of ev.Command. A bare mention, or unless Config.UnknownCommandReply is set, an unknown
+// command, asks for the IP address.
+func (h *Hnoss) dispatch(ev *ChatEvent) {
+	name := ev.Command
+	if name == "" {
+		name = ipCommand
+	}
+	c, ok := h.commands[name]
+	if !ok {
+		h.logger.Log(Infof("unknown command %q from %s", ev.Command, describeEvent(ev)))
+		if !h.config.UnknownCommandReply {
+			c = h.commands[ipCommand]
+		} else {
+			if err := h.Reply(ev, h.tr(ev, msgUnknownCommand, ev.Command, helpCommand)); err != nil {
+				h.logger.Log(err)
+			}
+			return
+		}
+	}
+	if err := c.Handler(h, ev); err != nil {
+		h.logger.Log(err)
+	}
+}
+
+// replyIP replies with the IP address as last checked. Unlike the refresh command it doesn't check, so it isn't
+// recorded as a run, which would put off the next scheduled check.
+func (h *Hnoss) replyIP(ev *ChatEvent) error {
+	h.logger.Log(Infof("replying to %s", describeEvent(ev)))
+	ip, err := h.getIP(true)
+	if err != nil {
+		return err
+	}
+	a := &Announcement{Kind: ReplyAnnouncement, IP: ip, Time: h.nowAdapter.Now()}
+	a.Message = h.message(ev, a, "")
+	return h.replyAnnouncement(ev, a)
+}
+
+func (h *Hnoss) uptime(ev *ChatEvent) error {
+	h.logger.Log(Infof("replying to uptime request from %s", describeEvent(ev)))
+	up := h.nowAdapter.Now().Sub(h.started).Round(time.Second)
+	return h.Reply(ev, h.tr(ev, msgUptime, up, formatTime(h.started)))
+}
+
+// help lists every command, or describes those named in ev.Args.
+func (h *Hnoss) help(ev *ChatEvent) error {
+	h.logger.Log(Infof("replying to help request from %s", describeEvent(ev)))
+	names := h.commandNames
+	if len(ev.Args) > 0 {
+		names = nil
+		for _, arg := range ev.Args {
+			name := strings.ToLower(arg)
+			if _, ok := h.commands[name]; !ok {
+				return h.Reply(ev, h.tr(ev, msgHelpUnknown, arg))
+			}
+			names = append(names, name)
+		}
+	}
+	var b strings.Builder
+	b.WriteString(h.tr(ev, msgHelp))
+	for _, name := range names {
+		c := h.commands[name]
+		b.WriteString("\n" + name)
+		if c.Usage != "" {
+			b.WriteString(" " + c.Usage)
+		}
+		desc, ok := h.lookup(ev, msgHelpPrefix+name)
+		if !ok {
+			desc = c.Description
+		}
+		b.WriteString(": " + desc)
+	}
+	return h.Reply(ev, b.String())
+}
@@ -0,0 +1,72 @@
+package hnoss
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommands(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	ipService := &mockIPAdaptor{ip: newIP(t, "5.6.7.8")}
+	now := &mockNowAdaptor{now: newTime(t, "2023-11-28T14:00:00Z")}
+	conf := &Config{IPMessageFormat: "%s"}
+	h := New(conf, logger, &mockTimeAdaptor{}, ipService, &mockIPAdaptor{}, &mockHistoryAdaptor{}, nil, nil,
+		&mockChatAdaptor{}, now)
+	h.ip = newIP(t, "1.2.3.4")
+	h.started = newTime(t, "2023-11-28T12:30:00Z")
+
+	var replies []string
+	request := func(text string) string {
+		ev := &ChatEvent{ChanID: "1234", Text: text, Reply: func(msg string) error {
+			replies = append(replies, msg)
+			return nil
+		}}
+		ev.Command, ev.Args = ParseCommand(text)
+		h.command(ev)
+		require.NotEmpty(t, replies)
+		return replies[len(replies)-1]
+	}
+
+	// A bare mention and ip reply with the cached address, refresh checks.
+	assert.Equal(t, "1.2.3.4", request(""))
+	assert.Equal(t, "1.2.3.4", request("ip"))
+	// Unknown commands ask for the IP address, unless configured to say so.
+	assert.Equal(t, "1.2.3.4", request("dance"))
+	// Asking for the IP address isn't recorded as a run.
+	assert.Equal(t, zeroTime, h.ran)
+	assert.Equal(t, "5.6.7.8", request("refresh"))
+	assert.Equal(t, now.now, h.ran)
+
+	assert.Equal(t, "up 1h30m0s since 2023-11-28T12:30:00Z", request("uptime"))
+	conf.UnknownCommandReply = true
+	assert.Equal(t, "I don't know how to dance, try help", request("dance"))
+
+	ipService.err = NewError("An error")
+	request("refresh")
+	assert.Contains(t, request("status"), "\nhealth: failing: An error")
+
+	require.NoError(t, h.RegisterCommand(&Command{
+		Name:        "Ping",
+		Description: "Check the bot is listening",
+		Handler: func(h *Hnoss, ev *ChatEvent) error {
+			return h.Reply(ev, "pong")
+		},
+	}))
+	assert.Equal(t, "pong", request("ping"))
+	assert.Error(t, h.RegisterCommand(&Command{Name: "ip", Handler: builtinCommands[0].Handler}))
+	assert.Error(t, h.RegisterCommand(&Command{Name: "nothing"}))
+
+	assert.Equal(t, "commands:\n"+
+		"ip: Show the IP address\n"+
+		"refresh: Check the IP address now\n"+
+		"status: Show the IP address, when it was last and will next be checked, and whether checking is working\n"+
+		"history [n]: Show recent IP address changes\n"+
+		"uptime: Show how long the bot has been running\n"+
+		"help [command]: Show what the bot can do\n"+
+		"ping: Check the bot is listening", request("help"))
+	assert.Equal(t, "commands:\nping: Check the bot is listening", request("help PING"))
+	assert.Equal(t, "unknown command: dance", request("help dance"))
+}
@@ -4,6 +4,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"text/template"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -17,22 +19,214 @@ type (
 		RanFile                   string
 		IPServiceURL              string
 		IPCacheFile               string
+		HistoryFile               string
+		SubscriptionFile          string
+		DigestFile                string
 		IPMessageFormat           string
+		MessageTemplates          map[AnnouncementKind]*template.Template
+		Ports                     []int
+		Locale                    string
+		LocaleDir                 string
+		LocaleChannels            map[string]string
+		LocaleGuilds              map[string]string
+		Catalogs                  map[string]Catalog
+		LocaleTemplates           map[string]map[AnnouncementKind]*template.Template
+		ChatAdapter               string
+		ChatAdapters              []string
+		OutboxFile                string
+		OutboxTTL                 time.Duration
 		DiscordBotToken           string
 		DiscordDefaultChannelName string
+		DiscordSlashCommands      bool
+		DiscordEphemeral          bool
+		DiscordChannelIDs         []string
+		DiscordGuildChannels      map[string][]string
+		DiscordPinStatus          bool
+		DiscordStatusFile         string
+		DiscordStatusTopic        bool
+		DiscordEmbeds             bool
+		DiscordEmbedTitles        map[string]string
+		DiscordEmbedColors        map[string]int
+		DiscordEmbedPorts         []int
+		DiscordEmbedFooter        string
+		DiscordPresence           bool
+		DiscordPresenceFormat     string
+		DiscordReadyTimeout       time.Duration
+		DiscordConnectAttempts    int
+		DiscordWebhookURL         string
+		DiscordWebhookUsername    string
+		DiscordWebhookAvatarURL   string
+		DiscordWebhookEdit        bool
+		DiscordWebhookMessageFile string
+		SlackAppToken             string
+		SlackBotToken             string
+		SlackDefaultChannel       string
+		MatrixHomeserverURL       string
+		MatrixAccessToken         string
+		MatrixDefaultRoomAlias    string
+		MatrixSyncFile            string
+		IRCServer                 string
+		IRCTLS                    bool
+		IRCNick                   string
+		IRCSASLUser               string
+		IRCSASLPassword           string
+		IRCDefaultChannel         string
+		IRCPrivateMessages        bool
+		TelegramBotToken          string
+		TelegramDefaultChatID     string
+		TelegramWebhookURL        string
+		TelegramWebhookListen     string
+		TelegramWebhookSecret     string
+		WebhookURL                string
+		WebhookMethod             string
+		WebhookHeaders            map[string]string
+		WebhookBodyTemplate       string
+		WebhookSecret             string
+		WebhookRetries            int
+		EmailServer               string
+		EmailFrom                 string
+		EmailTo                   []string
+		EmailSecurity             string
+		EmailAuth                 string
+		EmailUsername             string
+		EmailPassword             string
+		EmailSubjectTemplate      string
+		EmailBodyTemplate         string
+		PushURL                   string
+		PushTopic                 string
+		PushToken                 string
+		PushTitle                 string
+		PushPriorities            map[string]int
+		PushTags                  []string
+		PushClickURL              string
+		MQTTBroker                string
+		MQTTClientID              string
+		MQTTUsername              string
+		MQTTPassword              string
+		MQTTTopic                 string
+		MQTTDiscoveryPrefix       string
+		AnnounceErrors            bool
+		AccessAllowUsers          []string
+		AccessDenyUsers           []string
+		AccessAllowRoles          []string
+		AccessDenyRoles           []string
+		AccessAllowGuilds         []string
+		AccessDenyGuilds          []string
+		AccessAllowChannels       []string
+		AccessDenyChannels        []string
+		AccessDMOnly              bool
+		AccessRefusal             string
+		UnknownCommandReply       bool
+		AdminUsers                []string
+		AdminRoles                []string
 		LogFile                   string
 	}
 	yamlConfig struct {
-		Interval                  string `yaml:"interval"`
-		Offset                    string `yaml:"offset"`
-		PIDFile                   string `yaml:"pidFile"`
-		RanFile                   string `yaml:"ranFile"`
-		IPServiceURL              string `yaml:"ipServiceURL"`
-		IPCacheFile               string `yaml:"ipCacheFile"`
-		IPMessageFormat           string `yaml:"ipMessageFormat"`
-		DiscordBotToken           string `yaml:"discordBotToken"`
-		DiscordDefaultChannelName string `yaml:"discordDefaultChannelName"`
-		LogFile                   string `yaml:"logFile"`
+		Interval                  string              `yaml:"interval"`
+		Offset                    string              `yaml:"offset"`
+		PIDFile                   string              `yaml:"pidFile"`
+		RanFile                   string              `yaml:"ranFile"`
+		IPServiceURL              string              `yaml:"ipServiceURL"`
+		IPCacheFile               string              `yaml:"ipCacheFile"`
+		HistoryFile               string              `yaml:"historyFile"`
+		SubscriptionFile          string              `yaml:"subscriptionFile"`
+		DigestFile                string              `yaml:"digestFile"`
+		IPMessageFormat           string              `yaml:"ipMessageFormat"`
+		MessageTemplates          map[string]string   `yaml:"messageTemplates"`
+		Ports                     []int               `yaml:"ports"`
+		Locale                    string              `yaml:"locale"`
+		LocaleDir                 string              `yaml:"localeDir"`
+		LocaleChannels            map[string]string   `yaml:"localeChannels"`
+		LocaleGuilds              map[string]string   `yaml:"localeGuilds"`
+		ChatAdapter               string              `yaml:"chatAdapter"`
+		ChatAdapters              []string            `yaml:"chatAdapters"`
+		OutboxFile                string              `yaml:"outboxFile"`
+		OutboxTTL                 string              `yaml:"outboxTTL"`
+		DiscordBotToken           string              `yaml:"discordBotToken"`
+		DiscordDefaultChannelName string              `yaml:"discordDefaultChannelName"`
+		DiscordSlashCommands      bool                `yaml:"discordSlashCommands"`
+		DiscordEphemeral          bool                `yaml:"discordEphemeral"`
+		DiscordChannelIDs         []string            `yaml:"discordChannelIDs"`
+		DiscordGuildChannels      map[string][]string `yaml:"discordGuildChannels"`
+		DiscordPinStatus          bool                `yaml:"discordPinStatus"`
+		DiscordStatusFile         string              `yaml:"discordStatusFile"`
+		DiscordStatusTopic        bool                `yaml:"discordStatusTopic"`
+		DiscordEmbeds             bool                `yaml:"discordEmbeds"`
+		DiscordEmbedTitles        map[string]string   `yaml:"discordEmbedTitles"`
+		DiscordEmbedColors        map[string]int      `yaml:"discordEmbedColors"`
+		DiscordEmbedPorts         []int               `yaml:"discordEmbedPorts"`
+		DiscordEmbedFooter        string              `yaml:"discordEmbedFooter"`
+		DiscordPresence           bool                `yaml:"discordPresence"`
+		DiscordPresenceFormat     string              `yaml:"discordPresenceFormat"`
+		DiscordReadyTimeout       string              `yaml:"discordReadyTimeout"`
+		DiscordConnectAttempts    int                 `yaml:"discordConnectAttempts"`
+		DiscordWebhookURL         string              `yaml:"discordWebhookURL"`
+		DiscordWebhookUsername    string              `yaml:"discordWebhookUsername"`
+		DiscordWebhookAvatarURL   string              `yaml:"discordWebhookAvatarURL"`
+		DiscordWebhookEdit        bool                `yaml:"discordWebhookEdit"`
+		DiscordWebhookMessageFile string              `yaml:"discordWebhookMessageFile"`
+		SlackAppToken             string              `yaml:"slackAppToken"`
+		SlackBotToken             string              `yaml:"slackBotToken"`
+		SlackDefaultChannel       string              `yaml:"slackDefaultChannel"`
+		MatrixHomeserverURL       string              `yaml:"matrixHomeserverURL"`
+		MatrixAccessToken         string              `yaml:"matrixAccessToken"`
+		MatrixDefaultRoomAlias    string              `yaml:"matrixDefaultRoomAlias"`
+		MatrixSyncFile            string              `yaml:"matrixSyncFile"`
+		IRCServer                 string              `yaml:"ircServer"`
+		IRCTLS                    bool                `yaml:"ircTLS"`
+		IRCNick                   string              `yaml:"ircNick"`
+		IRCSASLUser               string              `yaml:"ircSASLUser"`
+		IRCSASLPassword           string              `yaml:"ircSASLPassword"`
+		IRCDefaultChannel         string              `yaml:"ircDefaultChannel"`
+		IRCPrivateMessages        bool                `yaml:"ircPrivateMessages"`
+		TelegramBotToken          string              `yaml:"telegramBotToken"`
+		TelegramDefaultChatID     string              `yaml:"telegramDefaultChatID"`
+		TelegramWebhookURL        string              `yaml:"telegramWebhookURL"`
+		TelegramWebhookListen     string              `yaml:"telegramWebhookListen"`
+		TelegramWebhookSecret     string              `yaml:"telegramWebhookSecret"`
+		WebhookURL                string              `yaml:"webhookURL"`
+		WebhookMethod             string              `yaml:"webhookMethod"`
+		WebhookHeaders            map[string]string   `yaml:"webhookHeaders"`
+		WebhookBodyTemplate       string              `yaml:"webhookBodyTemplate"`
+		WebhookSecret             string              `yaml:"webhookSecret"`
+		WebhookRetries            int                 `yaml:"webhookRetries"`
+		EmailServer               string              `yaml:"emailServer"`
+		EmailFrom                 string              `yaml:"emailFrom"`
+		EmailTo                   []string            `yaml:"emailTo"`
+		EmailSecurity             string              `yaml:"emailSecurity"`
+		EmailAuth                 string              `yaml:"emailAuth"`
+		EmailUsername             string              `yaml:"emailUsername"`
+		EmailPassword             string              `yaml:"emailPassword"`
+		EmailSubjectTemplate      string              `yaml:"emailSubjectTemplate"`
+		EmailBodyTemplate         string              `yaml:"emailBodyTemplate"`
+		PushURL                   string              `yaml:"pushURL"`
+		PushTopic                 string              `yaml:"pushTopic"`
+		PushToken                 string              `yaml:"pushToken"`
+		PushTitle                 string              `yaml:"pushTitle"`
+		PushPriorities            map[string]int      `yaml:"pushPriorities"`
+		PushTags                  []string            `yaml:"pushTags"`
+		PushClickURL              string              `yaml:"pushClickURL"`
+		MQTTBroker                string              `yaml:"mqttBroker"`
+		MQTTClientID              string              `yaml:"mqttClientID"`
+		MQTTUsername              string              `yaml:"mqttUsername"`
+		MQTTPassword              string              `yaml:"mqttPassword"`
+		MQTTTopic                 string              `yaml:"mqttTopic"`
+		MQTTDiscoveryPrefix       string              `yaml:"mqttDiscoveryPrefix"`
+		AnnounceErrors            bool                `yaml:"announceErrors"`
+		AccessAllowUsers          []string            `yaml:"accessAllowUsers"`
+		AccessDenyUsers           []string            `yaml:"accessDenyUsers"`
+		AccessAllowRoles          []string            `yaml:"accessAllowRoles"`
+		AccessDenyRoles           []string            `yaml:"accessDenyRoles"`
+		AccessAllowGuilds         []string            `yaml:"accessAllowGuilds"`
+		AccessDenyGuilds          []string            `yaml:"accessDenyGuilds"`
+		AccessAllowChannels       []string            `yaml:"accessAllowChannels"`
+		AccessDenyChannels        []string            `yaml:"accessDenyChannels"`
+		AccessDMOnly              bool                `yaml:"accessDMOnly"`
+		AccessRefusal             string              `yaml:"accessRefusal"`
+		UnknownCommandReply       bool                `yaml:"unknownCommandReply"`
+		AdminUsers                []string            `yaml:"adminUsers"`
+		AdminRoles                []string            `yaml:"adminRoles"`
+		LogFile                   string              `yaml:"logFile"`
 	}
 )
 
@@ -60,35 +254,224 @@ func (c *Config) Set(y *yamlConfig) error {
 	c.RanFile = y.RanFile
 	c.IPServiceURL = y.IPServiceURL
 	c.IPCacheFile = y.IPCacheFile
+	c.HistoryFile = y.HistoryFile
+	c.SubscriptionFile = y.SubscriptionFile
+	c.DigestFile = y.DigestFile
 	c.IPMessageFormat = y.IPMessageFormat
-	c.DiscordBotToken = y.DiscordBotToken
+	if c.MessageTemplates, err = parseMessageTemplates(y.MessageTemplates); err != nil {
+		return err
+	}
+	c.Ports = y.Ports
+	c.Locale = y.Locale
+	if c.Locale == "" {
+		c.Locale = defaultLocale
+	}
+	c.LocaleDir = y.LocaleDir
+	c.LocaleChannels = y.LocaleChannels
+	c.LocaleGuilds = y.LocaleGuilds
+	if c.Catalogs, err = loadCatalogs(y.LocaleDir); err != nil {
+		return err
+	}
+	if c.LocaleTemplates, err = parseLocaleTemplates(c.Catalogs); err != nil {
+		return err
+	}
+	if err = c.checkLocales(); err != nil {
+		return err
+	}
+	c.ChatAdapter = y.ChatAdapter
+	c.ChatAdapters = y.ChatAdapters
+	c.OutboxFile = y.OutboxFile
+	if y.OutboxTTL != "" {
+		c.OutboxTTL, err = time.ParseDuration(y.OutboxTTL)
+		if err != nil {
+			return ErrorWrapf(err, "config: failed to parse outboxTTL: %s", y.OutboxTTL)
+		}
+	}
 	c.DiscordDefaultChannelName = y.DiscordDefaultChannelName
+	c.DiscordSlashCommands = y.DiscordSlashCommands
+	c.DiscordEphemeral = y.DiscordEphemeral
+	c.DiscordChannelIDs = y.DiscordChannelIDs
+	c.DiscordGuildChannels = y.DiscordGuildChannels
+	c.DiscordPinStatus = y.DiscordPinStatus
+	c.DiscordStatusFile = y.DiscordStatusFile
+	c.DiscordStatusTopic = y.DiscordStatusTopic
+	c.DiscordEmbeds = y.DiscordEmbeds
+	c.DiscordEmbedTitles = y.DiscordEmbedTitles
+	c.DiscordEmbedColors = y.DiscordEmbedColors
+	c.DiscordEmbedPorts = y.DiscordEmbedPorts
+	c.DiscordEmbedFooter = y.DiscordEmbedFooter
+	c.DiscordPresence = y.DiscordPresence
+	c.DiscordPresenceFormat = y.DiscordPresenceFormat
+	if y.DiscordReadyTimeout != "" {
+		c.DiscordReadyTimeout, err = time.ParseDuration(y.DiscordReadyTimeout)
+		if err != nil {
+			return ErrorWrapf(err, "config: failed to parse discordReadyTimeout: %s", y.DiscordReadyTimeout)
+		}
+	}
+	c.DiscordConnectAttempts = y.DiscordConnectAttempts
+	c.DiscordWebhookUsername = y.DiscordWebhookUsername
+	c.DiscordWebhookAvatarURL = y.DiscordWebhookAvatarURL
+	c.DiscordWebhookEdit = y.DiscordWebhookEdit
+	c.DiscordWebhookMessageFile = y.DiscordWebhookMessageFile
+	c.SlackDefaultChannel = y.SlackDefaultChannel
+	c.MatrixHomeserverURL = y.MatrixHomeserverURL
+	c.MatrixDefaultRoomAlias = y.MatrixDefaultRoomAlias
+	c.MatrixSyncFile = y.MatrixSyncFile
+	c.IRCServer = y.IRCServer
+	c.IRCTLS = y.IRCTLS
+	c.IRCNick = y.IRCNick
+	c.IRCSASLUser = y.IRCSASLUser
+	c.IRCDefaultChannel = y.IRCDefaultChannel
+	c.IRCPrivateMessages = y.IRCPrivateMessages
+	c.TelegramDefaultChatID = y.TelegramDefaultChatID
+	c.TelegramWebhookURL = y.TelegramWebhookURL
+	c.TelegramWebhookListen = y.TelegramWebhookListen
+	c.WebhookURL = y.WebhookURL
+	c.WebhookMethod = y.WebhookMethod
+	c.WebhookHeaders = y.WebhookHeaders
+	c.WebhookBodyTemplate = y.WebhookBodyTemplate
+	c.WebhookRetries = y.WebhookRetries
+	c.EmailServer = y.EmailServer
+	c.EmailFrom = y.EmailFrom
+	c.EmailTo = y.EmailTo
+	c.EmailSecurity = y.EmailSecurity
+	c.EmailAuth = y.EmailAuth
+	c.EmailUsername = y.EmailUsername
+	c.EmailSubjectTemplate = y.EmailSubjectTemplate
+	c.EmailBodyTemplate = y.EmailBodyTemplate
+	c.PushURL = y.PushURL
+	c.PushTopic = y.PushTopic
+	c.PushTitle = y.PushTitle
+	c.PushPriorities = y.PushPriorities
+	c.PushTags = y.PushTags
+	c.PushClickURL = y.PushClickURL
+	c.MQTTBroker = y.MQTTBroker
+	c.MQTTClientID = y.MQTTClientID
+	c.MQTTUsername = y.MQTTUsername
+	c.MQTTTopic = y.MQTTTopic
+	c.MQTTDiscoveryPrefix = y.MQTTDiscoveryPrefix
+	c.AnnounceErrors = y.AnnounceErrors
+	c.AccessAllowUsers = y.AccessAllowUsers
+	c.AccessDenyUsers = y.AccessDenyUsers
+	c.AccessAllowRoles = y.AccessAllowRoles
+	c.AccessDenyRoles = y.AccessDenyRoles
+	c.AccessAllowGuilds = y.AccessAllowGuilds
+	c.AccessDenyGuilds = y.AccessDenyGuilds
+	c.AccessAllowChannels = y.AccessAllowChannels
+	c.AccessDenyChannels = y.AccessDenyChannels
+	c.AccessDMOnly = y.AccessDMOnly
+	c.AccessRefusal = y.AccessRefusal
+	c.UnknownCommandReply = y.UnknownCommandReply
+	c.AdminUsers = y.AdminUsers
+	c.AdminRoles = y.AdminRoles
+
+	secrets := []struct {
+		dest  *string
+		value string
+		name  string
+	}{
+		{&c.DiscordBotToken, y.DiscordBotToken, "discordBotToken"},
+		// The webhook URL contains its token.
+		{&c.DiscordWebhookURL, y.DiscordWebhookURL, "discordWebhookURL"},
+		{&c.SlackAppToken, y.SlackAppToken, "slackAppToken"},
+		{&c.SlackBotToken, y.SlackBotToken, "slackBotToken"},
+		{&c.MatrixAccessToken, y.MatrixAccessToken, "matrixAccessToken"},
+		{&c.IRCSASLPassword, y.IRCSASLPassword, "ircSASLPassword"},
+		{&c.TelegramBotToken, y.TelegramBotToken, "telegramBotToken"},
+		{&c.TelegramWebhookSecret, y.TelegramWebhookSecret, "telegramWebhookSecret"},
+		{&c.WebhookSecret, y.WebhookSecret, "webhookSecret"},
+		{&c.EmailPassword, y.EmailPassword, "emailPassword"},
+		{&c.PushToken, y.PushToken, "pushToken"},
+		{&c.MQTTPassword, y.MQTTPassword, "mqttPassword"},
+	}
+	for _, s := range secrets {
+		*s.dest = s.value
+		if *s.dest == "" {
+			if *s.dest, err = credential(s.name); err != nil {
+				return err
+			}
+		}
+	}
 	c.LogFile = y.LogFile
 	return nil
 }
 
-func DefaultConfig() *Config {
+// DefaultConfig returns the configuration used when there's no config file, which fails only if a credential can't
+// be read.
+func DefaultConfig() (*Config, error) {
 	y := defaultYAMLConfig()
 	c := &Config{}
-	err := c.Set(y)
-	if err != nil {
-		panic(err)
+	if err := c.Set(y); err != nil {
+		return nil, err
 	}
-	return c
+	return c, nil
 }
 
 func defaultYAMLConfig() *yamlConfig {
+	runtimeDir := systemdDir("RUNTIME_DIRECTORY", "/run")
+	cacheDir := systemdDir("CACHE_DIRECTORY", "/var/cache/hnoss")
+	stateDir := systemdDir("STATE_DIRECTORY", "/var/lib/hnoss")
+	logsDir := systemdDir("LOGS_DIRECTORY", "/var/log")
 	return &yamlConfig{
-		Interval:        "1h",
-		Offset:          "2023-11-28T00:00:00Z",
-		PIDFile:         "/run/hnoss.pid",
-		RanFile:         "/var/cache/hnoss/ran",
-		IPCacheFile:     "/var/cache/hnoss/ip",
-		IPMessageFormat: "%s",
-		LogFile:         "/var/log/hnoss.log",
+		Interval:                  "1h",
+		Offset:                    "2023-11-28T00:00:00Z",
+		PIDFile:                   filepath.Join(runtimeDir, "hnoss.pid"),
+		RanFile:                   filepath.Join(cacheDir, "ran"),
+		IPCacheFile:               filepath.Join(cacheDir, "ip"),
+		HistoryFile:               filepath.Join(stateDir, "history"),
+		SubscriptionFile:          filepath.Join(stateDir, "subscriptions"),
+		DigestFile:                filepath.Join(cacheDir, "digest"),
+		IPMessageFormat:           "%s",
+		Locale:                    defaultLocale,
+		ChatAdapter:               "discord",
+		OutboxFile:                filepath.Join(stateDir, "outbox"),
+		OutboxTTL:                 "24h",
+		DiscordStatusFile:         filepath.Join(stateDir, "discord-status"),
+		DiscordPresenceFormat:     "%s",
+		DiscordReadyTimeout:       "30s",
+		DiscordConnectAttempts:    3,
+		DiscordWebhookMessageFile: filepath.Join(stateDir, "discord-webhook-message"),
+		MatrixSyncFile:            filepath.Join(stateDir, "matrix-sync"),
+		IRCNick:                   "hnoss",
+		TelegramWebhookListen:     "127.0.0.1:8443",
+		WebhookMethod:             "POST",
+		WebhookRetries:            3,
+		MQTTClientID:              "hnoss",
+		MQTTTopic:                 "hnoss",
+		MQTTDiscoveryPrefix:       "homeassistant",
+		AccessRefusal:             "sorry, you're not allowed to ask me that",
+		LogFile:                   filepath.Join(logsDir, "hnoss.log"),
 	}
 }
 
+// systemdDir returns the first directory in the systemd environment variable env, e.g. set by
+// RuntimeDirectory= in a unit file, or def if env is unset.
+func systemdDir(env, def string) string {
+	dirs := os.Getenv(env)
+	if dirs == "" {
+		return def
+	}
+	return strings.SplitN(dirs, ":", 2)[0]
+}
+
+// credential returns the contents of the systemd credential name, e.g. set by LoadCredential= in a unit
+// file, or the empty string if there is no such credential.
+func credential(name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", nil
+	}
+	path := filepath.Join(dir, name)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", FatalWrapf(err, "config: failed to read credential file: %s", path)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
 func ConfigureFromFile(path string) (conf *Config, err error) {
 	file, closeFile := openFile(path, "config", &err)
 	if err != nil {
@@ -121,7 +504,7 @@ func ConfigureFromReadSeeker(reader io.ReadSeeker) (*Config, error) {
 func openFile(path, desc string, err *error) (file *os.File, closeFile func()) {
 	file, fErr := os.Open(path)
 	if fErr != nil {
-		fErr = ErrorWrapf(*err, "failed to open %s file: %s", desc, path)
+		fErr = ErrorWrapf(fErr, "failed to open %s file: %s", desc, path)
 		multiError(err, fErr)
 		return
 	}
@@ -145,6 +528,46 @@ func createFile(path, desc string, err *error) (file *os.File, closeFile func())
 	return
 }
 
+func appendFile(path, desc string, err *error) (file *os.File, closeFile func()) {
+	fErr := mkDir(path, desc)
+	if fErr != nil {
+		multiError(err, fErr)
+		return
+	}
+	file, fErr = os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if fErr != nil {
+		fErr = ErrorWrapf(fErr, "failed to open %s file for appending: %s", desc, path)
+		multiError(err, fErr)
+		return
+	}
+	closeFile = closeFileFunc(path, desc, err, file)
+	return
+}
+
+// readStringFile returns the trimmed contents of path, or the empty string if it doesn't exist.
+func readStringFile(path, desc string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", ErrorWrapf(err, "failed to read %s file: %s", desc, path)
+	}
+	return trim(b), nil
+}
+
+func writeStringFile(path, desc, s string) (err error) {
+	file, closeFile := createFile(path, desc, &err)
+	if err != nil {
+		return
+	}
+	if _, err = file.WriteString(s); err != nil {
+		err = ErrorWrapf(err, "failed to write to %s file", desc)
+	}
+	closeFile()
+	return
+}
+
 func mkDir(path, desc string) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return ErrorWrapf(err, "failed to make directory for %s file: %s", desc, path)
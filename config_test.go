@@ -1,6 +1,8 @@
 package hnoss
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -22,11 +24,77 @@ func TestConfig(t *testing.T) {
 		RanFile:                   "run/ran",
 		IPServiceURL:              "http://localhost:45782/ip",
 		IPCacheFile:               "run/ip",
+		HistoryFile:               "run/history",
+		SubscriptionFile:          "run/subscriptions",
+		DigestFile:                "run/digest",
 		IPMessageFormat:           "%s:2456",
+		Ports:                     []int{2456, 2457},
+		Locale:                    "en",
+		LocaleDir:                 "locales",
+		LocaleGuilds:              map[string]string{"1234": "nb"},
+		ChatAdapter:               "discord",
+		OutboxFile:                "run/outbox",
+		OutboxTTL:                 24 * time.Hour,
 		DiscordBotToken:           "1234",
 		DiscordDefaultChannelName: "valheim",
+		DiscordEphemeral:          true,
+		DiscordStatusFile:         "run/discord-status",
+		DiscordPresenceFormat:     "%s",
+		DiscordEmbedColors:        map[string]int{"change": 0x00ff00},
+		DiscordReadyTimeout:       30 * time.Second,
+		DiscordConnectAttempts:    3,
+		DiscordWebhookMessageFile: "run/discord-webhook-message",
+		MatrixSyncFile:            "run/matrix-sync",
+		IRCNick:                   "hnoss",
+		TelegramWebhookListen:     "127.0.0.1:8443",
+		WebhookMethod:             "POST",
+		WebhookRetries:            3,
+		MQTTClientID:              "hnoss",
+		MQTTTopic:                 "hnoss",
+		MQTTDiscoveryPrefix:       "homeassistant",
+		AccessRefusal:             "sorry, you're not allowed to ask me that",
+		AdminUsers:                []string{"5678"},
 		LogFile:                   "run/log",
 	}
 
+	// The catalogs themselves are checked by TestLoadCatalogs.
+	assert.Len(t, conf.Catalogs, 2)
+	assert.Contains(t, conf.Catalogs, "nb")
+	conf.Catalogs = nil
 	assert.Equal(t, expected, conf)
 }
+
+func TestDefaultConfigSystemd(t *testing.T) {
+	t.Setenv("RUNTIME_DIRECTORY", "/run/hnoss")
+	t.Setenv("CACHE_DIRECTORY", "/var/cache/hnoss:/var/cache/other")
+	t.Setenv("STATE_DIRECTORY", "/var/lib/private/hnoss")
+	t.Setenv("LOGS_DIRECTORY", "/var/log/hnoss")
+	t.Setenv("CREDENTIALS_DIRECTORY", "testdata/credentials")
+
+	conf, err := DefaultConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "/run/hnoss/hnoss.pid", conf.PIDFile)
+	assert.Equal(t, "/var/cache/hnoss/ran", conf.RanFile)
+	assert.Equal(t, "/var/cache/hnoss/digest", conf.DigestFile)
+	assert.Equal(t, "/var/cache/hnoss/ip", conf.IPCacheFile)
+	assert.Equal(t, "/var/lib/private/hnoss/history", conf.HistoryFile)
+	assert.Equal(t, "/var/lib/private/hnoss/outbox", conf.OutboxFile)
+	assert.Equal(t, "/var/log/hnoss/hnoss.log", conf.LogFile)
+	assert.Equal(t, "5678", conf.DiscordBotToken)
+
+	// Token in config file takes precedence over credential.
+	conf, err = ConfigureFromFile("testdata/hnoss.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "1234", conf.DiscordBotToken)
+}
+
+func TestDefaultConfigCredentialError(t *testing.T) {
+	// A directory can't be read as a credential file.
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "discordBotToken"), 0755))
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	_, err := DefaultConfig()
+	require.Error(t, err)
+	var f *Fatal
+	assert.ErrorAs(t, err, &f)
+}
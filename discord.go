@@ -1,64 +1,423 @@
 package hnoss
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
 )
 
-type DiscordChatAdapter struct {
-	token           string
-	defaultChanName string
+type (
+	DiscordChatAdapter struct {
+		token           string
+		defaultChanName string
+		opts            DiscordOptions
 
-	defaultChanID string
-	c             chan string
-	session       *discordgo.Session
-	wg            sync.WaitGroup
+		// targets maps the ID of each channel announcements are posted to, to the ID of its guild.
+		targets  map[string]string
+		targetMu sync.Mutex
+		// statusMessages maps channel IDs to the IDs of their status messages, nil until loaded.
+		statusMessages map[string]string
+		topics         map[string]string
+		// commands are offered as slash commands, set by SetCommands.
+		commands []*Command
+		// statusMu serializes status updates, so concurrent ones can't both post a status message to a channel.
+		statusMu sync.Mutex
+		// presence is the activity text last set, re-applied on Ready.
+		presence       string
+		presenceMu     sync.Mutex
+		updatePresence func(text string) error
+		appID          string
+		readyErr       error
+		c              chan *ChatEvent
+		session        *discordgo.Session
+		state          discordState
+		stateMu        sync.Mutex
+		// readyTimer closes the gateway connection if it isn't ready in time, setting timedOut.
+		readyTimer *time.Timer
+		timedOut   bool
+		connMu     sync.Mutex
+		// readyC receives when a Ready or Resumed event has been handled while connecting.
+		readyC chan struct{}
+	}
+	// DiscordOptions configures optional DiscordChatAdapter behaviour.
+	DiscordOptions struct {
+		// SlashCommands registers application commands on Ready and removes them on Close.
+		SlashCommands bool
+		// Ephemeral makes replies to application commands visible only to the user who used them.
+		Ephemeral bool
+		// ChannelIDs are announced to in addition to the channels resolved by name.
+		ChannelIDs []string
+		// GuildChannels maps a guild ID to the names or IDs of channels in that guild to announce to, in addition to
+		// any channel named defaultChanName in every guild.
+		GuildChannels map[string][]string
+		// PinStatus keeps a pinned status message up to date in each channel, instead of posting IP address
+		// changes.
+		PinStatus bool
+		// StatusFile persists the IDs of status messages, so they can be edited after a restart.
+		StatusFile string
+		// StatusTopic sets each channel's topic to the IP address.
+		StatusTopic bool
+		// Embeds sends announcements and replies as embeds.
+		Embeds bool
+		// EmbedTitles and EmbedColors map an AnnouncementKind, e.g. "change", "reply" or "error", to the title and
+		// colour of its embeds, overriding the defaults.
+		EmbedTitles map[string]string
+		EmbedColors map[string]int
+		// EmbedPorts are shown in a field of every embed.
+		EmbedPorts []int
+		// EmbedFooter defaults to the hostname.
+		EmbedFooter string
+		// Presence sets the bot's activity to the IP address formatted with PresenceFormat, e.g. "%s:2456" shows as
+		// "Playing 1.2.3.4:2456".
+		Presence       bool
+		PresenceFormat string
+		// ReadyTimeout bounds the wait for Ready or Resumed after connecting to the gateway, 30s if zero.
+		ReadyTimeout time.Duration
+		// ConnectAttempts is how many times Listen tries to connect, backing off between attempts, at least once.
+		ConnectAttempts int
+		// Logger receives connection state changes that happen outside of Listen and Close.
+		Logger *Logger
+		// Catalog is the locale of embeds, status messages and application command descriptions, English if nil.
+		Catalog Catalog
+		// Catalogs, keyed by locale, translate application command descriptions for users of the locales Discord
+		// supports.
+		Catalogs map[string]Catalog
+	}
+	// discordState is the state of the gateway connection.
+	discordState int
+)
+
+var historyMin = 1.0
+
+// discordArgsOption is the slash command option of commands without options of their own, taking all arguments.
+const discordArgsOption = "args"
+
+const (
+	// discordDisconnected is the initial state, and the state after Close or a failed Listen.
+	discordDisconnected discordState = iota
+	// discordConnecting is the state during Listen, until Ready.
+	discordConnecting
+	// discordConnected is the state after Ready or Resumed.
+	discordConnected
+	// discordReconnecting is the state after the connection is lost, while discordgo reconnects.
+	discordReconnecting
+)
+
+var (
+	// discordBackoff is the delay before the second attempt to connect, doubling after each subsequent attempt up to
+	// discordMaxBackoff.
+	discordBackoff    = time.Second
+	discordMaxBackoff = time.Minute
+)
+
+func (s discordState) String() string {
+	switch s {
+	case discordDisconnected:
+		return "disconnected"
+	case discordConnecting:
+		return "connecting"
+	case discordConnected:
+		return "connected"
+	case discordReconnecting:
+		return "reconnecting"
+	default:
+		return "unknown"
+	}
 }
 
-func NewDiscordChatAdapter(token, defaultChanName string) *DiscordChatAdapter {
+func NewDiscordChatAdapter(token, defaultChanName string, opts DiscordOptions) *DiscordChatAdapter {
 	d := &DiscordChatAdapter{
 		token:           token,
 		defaultChanName: defaultChanName,
-		c:               make(chan string),
+		opts:            opts,
+		targets:         map[string]string{},
+		topics:          map[string]string{},
+		readyC:          make(chan struct{}, 1),
+		c:               make(chan *ChatEvent),
 	}
 	// New never actually returns an error
 	d.session, _ = discordgo.New("Bot " + d.token)
+	d.session.Dialer = &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 45 * time.Second,
+		NetDialContext:   d.dialGateway,
+	}
 	d.session.AddHandler(d.messageCreate)
+	d.session.AddHandler(d.interactionCreate)
+	d.session.AddHandler(d.connected)
 	d.session.AddHandler(d.ready)
+	d.session.AddHandler(d.resumed)
+	d.session.AddHandler(d.disconnect)
+	d.session.AddHandler(d.guildCreate)
+	d.session.AddHandler(d.guildDelete)
+	d.updatePresence = func(text string) error {
+		return d.session.UpdateGameStatus(0, text)
+	}
 	return d
 }
 
-func (d *DiscordChatAdapter) Chan() <-chan string {
+func (d *DiscordChatAdapter) Chan() <-chan *ChatEvent {
 	return d.c
 }
 
+// Listen connects to Discord and waits for it to be ready, retrying with backoff up to opts.ConnectAttempts times.
+// If the connection is lost discordgo reconnects by itself, so Listen only warns until it has.
 func (d *DiscordChatAdapter) Listen() error {
+	switch state := d.getState(); state {
+	case discordConnected:
+		return NewWarn("Discord already connected")
+	case discordConnecting, discordReconnecting:
+		return Warnf("Discord %s", state)
+	}
+	backoff := discordBackoff
+	for attempt := 1; ; attempt++ {
+		err := d.connect()
+		var e *Error
+		if err == nil || !errors.As(err, &e) || attempt >= d.opts.ConnectAttempts {
+			return err
+		}
+		d.log(WarnWrapf(e, "Discord connection attempt %d failed, retrying in %s", attempt, backoff))
+		time.Sleep(backoff)
+		backoff = min(backoff*2, discordMaxBackoff)
+	}
+}
+
+// connect makes a single attempt to open the session and wait for Ready. Opening blocks until Discord sends Ready, so
+// the wait is bounded by dialGateway.
+func (d *DiscordChatAdapter) connect() error {
+	// Drop any Ready left from a previous attempt.
+	select {
+	case <-d.readyC:
+	default:
+	}
+	d.setState(discordConnecting)
+	start := time.Now()
 	err := d.session.Open()
-	switch err {
-	case discordgo.ErrWSAlreadyOpen:
+	switch {
+	case err == nil:
+	case errors.Is(err, discordgo.ErrWSAlreadyOpen):
+		d.setState(discordConnected)
 		return WarnWrap(err, "Discord already connected")
-	case nil:
-		// wait for Discord ready event before returning.
-		d.wg.Add(1)
-		d.wg.Wait()
-		return NewInfo("connected to Discord")
+	case d.hasTimedOut():
+		d.setState(discordDisconnected)
+		return Errorf("timed out after %s waiting for Discord to be ready", d.readyTimeout())
 	default:
+		d.setState(discordDisconnected)
 		return ErrorWrap(err, "failed to open Discord session")
 	}
+	// Ready has been received, wait for it to be handled.
+	timer := time.NewTimer(d.readyTimeout() - time.Since(start))
+	defer timer.Stop()
+	select {
+	case <-d.readyC:
+		if d.readyErr != nil {
+			return d.readyErr
+		}
+		return NewInfo("connected to Discord")
+	case <-timer.C:
+		// Disconnected first, so the Disconnect event isn't taken for a lost connection.
+		d.setState(discordDisconnected)
+		if err = d.session.Close(); err != nil {
+			d.log(WarnWrap(err, "failed to close Discord session"))
+		}
+		return Errorf("timed out after %s waiting for Discord to be ready", d.readyTimeout())
+	}
+}
+
+// dialGateway dials the gateway, closing the connection if Ready or Resumed doesn't follow within the ready timeout, so
+// neither Listen nor discordgo's own reconnects wait forever. The timer is stopped by the Connect event.
+func (d *DiscordChatAdapter) dialGateway(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+	if d.readyTimer != nil {
+		d.readyTimer.Stop()
+	}
+	d.timedOut = false
+	d.readyTimer = time.AfterFunc(d.readyTimeout(), func() {
+		d.connMu.Lock()
+		d.timedOut = true
+		d.connMu.Unlock()
+		_ = conn.Close()
+	})
+	return conn, nil
+}
+
+func (d *DiscordChatAdapter) connected(_ *discordgo.Session, _ *discordgo.Connect) {
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+	if d.readyTimer != nil {
+		d.readyTimer.Stop()
+	}
+}
+
+// hasTimedOut returns whether the last gateway connection was closed by the ready timeout.
+func (d *DiscordChatAdapter) hasTimedOut() bool {
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+	return d.timedOut
+}
+
+func (d *DiscordChatAdapter) readyTimeout() time.Duration {
+	if d.opts.ReadyTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return d.opts.ReadyTimeout
 }
 
-// Handler for Discord ready event.
 func (d *DiscordChatAdapter) ready(s *discordgo.Session, r *discordgo.Ready) {
 	for _, guild := range r.Guilds {
 		chans, _ := s.GuildChannels(guild.ID)
-		for _, c := range chans {
-			if c.Type == discordgo.ChannelTypeGuildText && c.Name == d.defaultChanName {
-				d.defaultChanID = c.ID
+		d.resolveGuild(guild.ID, chans)
+	}
+	d.readyErr = nil
+	// Presence doesn't survive a new gateway session.
+	d.presenceMu.Lock()
+	if d.presence != "" {
+		if err := d.updatePresence(d.presence); err != nil {
+			d.readyErr = WarnWrap(err, "failed to set Discord presence")
+		}
+	}
+	d.presenceMu.Unlock()
+	if d.opts.SlashCommands {
+		d.appID = r.Application.ID
+		// Overwriting removes any commands no longer registered.
+		if _, err := s.ApplicationCommandBulkOverwrite(d.appID, "", d.applicationCommands()); err != nil {
+			d.readyErr = WarnWrap(err, "failed to register Discord application commands")
+		}
+	}
+	d.becomeConnected("reconnected to Discord")
+}
+
+func (d *DiscordChatAdapter) resumed(_ *discordgo.Session, _ *discordgo.Resumed) {
+	d.becomeConnected("resumed Discord session")
+}
+
+// becomeConnected handles Ready and Resumed, which may arrive any number of times, signalling Listen when connecting
+// and logging msg when reconnecting.
+func (d *DiscordChatAdapter) becomeConnected(msg string) {
+	d.stateMu.Lock()
+	prev := d.state
+	if prev == discordConnecting || prev == discordReconnecting {
+		d.state = discordConnected
+	}
+	d.stateMu.Unlock()
+	switch prev {
+	case discordConnecting:
+		select {
+		case d.readyC <- struct{}{}:
+		default:
+		}
+	case discordReconnecting:
+		if d.readyErr != nil {
+			d.log(d.readyErr)
+		}
+		d.log(NewInfo(msg))
+	}
+}
+
+// disconnect handles the Disconnect event, sent whenever the gateway connection closes, including by Close and
+// before each of discordgo's reconnect attempts.
+func (d *DiscordChatAdapter) disconnect(_ *discordgo.Session, _ *discordgo.Disconnect) {
+	d.stateMu.Lock()
+	prev := d.state
+	if prev == discordConnected {
+		d.state = discordReconnecting
+	}
+	d.stateMu.Unlock()
+	if prev == discordConnected {
+		d.log(NewWarn("lost connection to Discord, reconnecting"))
+	}
+}
+
+func (d *DiscordChatAdapter) getState() discordState {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.state
+}
+
+func (d *DiscordChatAdapter) setState(state discordState) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	d.state = state
+}
+
+func (d *DiscordChatAdapter) log(err error) {
+	if d.opts.Logger != nil {
+		d.opts.Logger.Log(err)
+	}
+}
+
+// Handler for Discord guild create event, sent when the bot joins a guild or one becomes available.
+func (d *DiscordChatAdapter) guildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
+	chans := g.Channels
+	if chans == nil {
+		chans, _ = s.GuildChannels(g.ID)
+	}
+	d.resolveGuild(g.ID, chans)
+}
+
+// Handler for Discord guild delete event, sent when the bot leaves a guild or one becomes unavailable.
+func (d *DiscordChatAdapter) guildDelete(_ *discordgo.Session, g *discordgo.GuildDelete) {
+	d.resolveGuild(g.ID, nil)
+}
+
+// resolveGuild replaces the targets in guildID with those of chans, matched by defaultChanName or
+// opts.GuildChannels.
+func (d *DiscordChatAdapter) resolveGuild(guildID string, chans []*discordgo.Channel) {
+	d.targetMu.Lock()
+	defer d.targetMu.Unlock()
+	for id, g := range d.targets {
+		if g == guildID {
+			delete(d.targets, id)
+		}
+	}
+	wanted := d.opts.GuildChannels[guildID]
+	for _, c := range chans {
+		if c.Type != discordgo.ChannelTypeGuildText {
+			continue
+		}
+		if c.Name == d.defaultChanName {
+			d.targets[c.ID] = guildID
+			continue
+		}
+		for _, w := range wanted {
+			if c.Name == w || c.ID == w {
+				d.targets[c.ID] = guildID
+				break
 			}
 		}
 	}
-	d.wg.Done()
+}
+
+// targetIDs returns the IDs of the channels to announce to, sorted, without duplicates.
+func (d *DiscordChatAdapter) targetIDs() []string {
+	d.targetMu.Lock()
+	defer d.targetMu.Unlock()
+	ids := make([]string, 0, len(d.targets)+len(d.opts.ChannelIDs))
+	for id := range d.targets {
+		ids = append(ids, id)
+	}
+	for _, id := range d.opts.ChannelIDs {
+		if _, ok := d.targets[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
 }
 
 // Handler called when anyone creates a message in a Guild that the bot is a member of.
@@ -70,25 +429,263 @@ func (d *DiscordChatAdapter) messageCreate(s *discordgo.Session, m *discordgo.Me
 	// Answer if mentioned
 	for _, mention := range m.Mentions {
 		if mention.ID == s.State.User.ID {
-			d.c <- m.ChannelID
+			d.c <- d.newChatEvent(s, m.Message)
+			return
+		}
+	}
+}
+
+func (d *DiscordChatAdapter) newChatEvent(s *discordgo.Session, m *discordgo.Message) *ChatEvent {
+	ev := &ChatEvent{
+		ChanID:     m.ChannelID,
+		GuildID:    m.GuildID,
+		AuthorID:   m.Author.ID,
+		AuthorName: m.Author.String(),
+		Text:       m.Content,
+		Reply: func(msg string) error {
+			if _, err := s.ChannelMessageSendReply(m.ChannelID, msg, m.Reference()); err != nil {
+				return ErrorWrap(err, "failed to send Discord reply")
+			}
+			return nil
+		},
+	}
+	if m.Member != nil {
+		ev.RoleIDs = m.Member.Roles
+	}
+	ev.ReplyDirect = func(msg string) error {
+		return d.sendDirect(s, m.Author.ID, msg)
+	}
+	if m.GuildID == "" {
+		ev.ReplyDirect = ev.Reply
+	}
+	if d.opts.Embeds {
+		ev.AnnounceReply = func(a *Announcement) error {
+			_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+				Embeds:    []*discordgo.MessageEmbed{d.newEmbed(a)},
+				Reference: m.Reference(),
+			})
+			if err != nil {
+				return ErrorWrap(err, "failed to send Discord reply")
+			}
+			return nil
+		}
+	}
+	ev.Command, ev.Args = ParseCommand(stripMention(m.Content, s.State.User.ID))
+	return ev
+}
+
+// Handler called when anyone uses one of the bot's application commands.
+func (d *DiscordChatAdapter) interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+	var flags discordgo.MessageFlags
+	if d.opts.Ephemeral {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+	// Defer the response, Discord requires one within 3 seconds and fetching the IP may take longer.
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: flags},
+	})
+	if err != nil {
+		d.log(ErrorWrap(err, "failed to defer Discord interaction response"))
+		return
+	}
+	data := i.ApplicationCommandData()
+	ev := &ChatEvent{
+		ChanID:  i.ChannelID,
+		GuildID: i.GuildID,
+		Text:    "/" + data.Name,
+		Command: data.Name,
+		Reply: func(msg string) error {
+			if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &msg}); err != nil {
+				return ErrorWrap(err, "failed to send Discord interaction response")
+			}
+			return nil
+		},
+	}
+	// Otherwise the user sees the deferred response "thinking" until Discord gives up.
+	ev.Dismiss = func() error {
+		if err := s.InteractionResponseDelete(i.Interaction); err != nil {
+			return ErrorWrap(err, "failed to delete Discord interaction response")
+		}
+		return nil
+	}
+	if d.opts.Embeds {
+		ev.AnnounceReply = func(a *Announcement) error {
+			embeds := []*discordgo.MessageEmbed{d.newEmbed(a)}
+			if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &embeds}); err != nil {
+				return ErrorWrap(err, "failed to send Discord interaction response")
+			}
+			return nil
+		}
+	}
+	for _, o := range data.Options {
+		arg := fmt.Sprint(o.Value)
+		if o.Type == discordgo.ApplicationCommandOptionInteger {
+			arg = strconv.FormatInt(o.IntValue(), 10)
+		}
+		if o.Name == discordArgsOption {
+			ev.Args = append(ev.Args, strings.Fields(arg)...)
+		} else {
+			ev.Args = append(ev.Args, arg)
+		}
+		ev.Text += " " + arg
+	}
+	user := i.User
+	if i.Member != nil {
+		user = i.Member.User
+		ev.RoleIDs = i.Member.Roles
+	}
+	if user != nil {
+		ev.AuthorID = user.ID
+		ev.AuthorName = user.String()
+	}
+	if i.GuildID == "" || d.opts.Ephemeral {
+		ev.ReplyDirect = ev.Reply
+	} else if user != nil {
+		ev.ReplyDirect = func(msg string) error {
+			return d.sendDirect(s, user.ID, msg)
 		}
+		// The deferred response needs answering.
+		ev.DirectNotice = ev.Reply
 	}
+	d.c <- ev
+}
+
+// SetCommands sets the commands registered as slash commands on Ready.
+func (d *DiscordChatAdapter) SetCommands(commands []*Command) {
+	d.commands = commands
+}
+
+// applicationCommands returns the application commands of d.commands, described in the locale of opts.Catalog, or by
+// Command.Description if it has no description, and translated from opts.Catalogs. Commands other than the built-in
+// ones take their arguments, if any, as one option.
+func (d *DiscordChatAdapter) applicationCommands() []*discordgo.ApplicationCommand {
+	command := func(cmd *Command, options ...*discordgo.ApplicationCommandOption) *discordgo.ApplicationCommand {
+		id := msgCommandPrefix + cmd.Name
+		desc := d.opts.Catalog.text(id)
+		if desc == "" {
+			desc = cmd.Description
+		}
+		c := &discordgo.ApplicationCommand{Name: cmd.Name, Description: desc, Options: options}
+		if l := d.localizations(id); l != nil {
+			c.DescriptionLocalizations = &l
+		}
+		return c
+	}
+	option := func(command, name string, typ discordgo.ApplicationCommandOptionType) *discordgo.ApplicationCommandOption {
+		id := msgCommandPrefix + command + "." + name
+		return &discordgo.ApplicationCommandOption{Type: typ, Name: name, Description: d.opts.Catalog.text(id),
+			DescriptionLocalizations: d.localizations(id)}
+	}
+	n := option(historyCommand, "n", discordgo.ApplicationCommandOptionInteger)
+	n.MinValue = &historyMin
+	n.MaxValue = maxHistoryLength
+	options := map[string][]*discordgo.ApplicationCommandOption{
+		historyCommand:   {n},
+		helpCommand:      {option(helpCommand, "command", discordgo.ApplicationCommandOptionString)},
+		subscribeCommand: {option(subscribeCommand, "events", discordgo.ApplicationCommandOptionString)},
+		formatCommand:    {option(formatCommand, "template", discordgo.ApplicationCommandOptionString)},
+	}
+	commands := make([]*discordgo.ApplicationCommand, 0, len(d.commands))
+	for _, cmd := range d.commands {
+		o, ok := options[cmd.Name]
+		if !ok && cmd.Usage != "" {
+			o = []*discordgo.ApplicationCommandOption{{Type: discordgo.ApplicationCommandOptionString,
+				Name: discordArgsOption, Description: cmd.Usage}}
+		}
+		commands = append(commands, command(cmd, o...))
+	}
+	return commands
+}
+
+// localizations returns the translations of message id in opts.Catalogs, keyed by Discord locale, nil if none.
+func (d *DiscordChatAdapter) localizations(id string) map[discordgo.Locale]string {
+	var l map[discordgo.Locale]string
+	for locale, c := range d.opts.Catalogs {
+		dl, ok := discordLocale(locale)
+		s, translated := c[id]
+		if !ok || !translated {
+			continue
+		}
+		if l == nil {
+			l = make(map[discordgo.Locale]string)
+		}
+		l[dl] = s
+	}
+	return l
+}
+
+// discordLocale returns the Discord locale of locale, false if Discord doesn't support it. Discord calls Norwegian
+// Bokmål "no".
+func discordLocale(locale string) (discordgo.Locale, bool) {
+	if locale == "nb" {
+		return discordgo.Norwegian, true
+	}
+	l := discordgo.Locale(locale)
+	_, ok := discordgo.Locales[l]
+	return l, ok
+}
+
+// sendDirect sends msg in a direct message to userID.
+func (d *DiscordChatAdapter) sendDirect(s *discordgo.Session, userID, msg string) error {
+	c, err := s.UserChannelCreate(userID)
+	if err != nil {
+		return ErrorWrapf(err, "failed to open Discord direct message channel to user: %s", userID)
+	}
+	if _, err = s.ChannelMessageSend(c.ID, msg); err != nil {
+		return ErrorWrapf(err, "failed to send Discord direct message to user: %s", userID)
+	}
+	return nil
+}
+
+// Remove both forms of Discord user mention for userID from content.
+func stripMention(content, userID string) string {
+	return strings.NewReplacer("<@"+userID+">", "", "<@!"+userID+">", "").Replace(content)
 }
 
 func (d *DiscordChatAdapter) Close() error {
+	if d.opts.SlashCommands && d.appID != "" {
+		if _, err := d.session.ApplicationCommandBulkOverwrite(d.appID, "", []*discordgo.ApplicationCommand{}); err != nil {
+			return ErrorWrap(err, "failed to remove Discord application commands")
+		}
+	}
+	d.setState(discordDisconnected)
 	if err := d.session.Close(); err != nil {
 		return ErrorWrap(err, "failed to close Discord session")
 	}
 	return nil
 }
 
+// Post msg to chanID, or to every target channel if chanID is empty, a failure to post to one channel doesn't
+// stop the others.
 func (d *DiscordChatAdapter) Post(chanID, msg string) error {
-	if chanID == "" {
-		chanID = d.defaultChanID
+	if chanID != "" {
+		return d.send(chanID, msg)
 	}
-	_, err := d.session.ChannelMessageSend(chanID, msg)
-	if err != nil {
-		return ErrorWrap(err, "failed to send Discord message")
+	return d.eachTarget(func(id string) error {
+		return d.send(id, msg)
+	})
+}
+
+// eachTarget calls f with the ID of every target channel, returning the errors of all that fail.
+func (d *DiscordChatAdapter) eachTarget(f func(chanID string) error) error {
+	ids := d.targetIDs()
+	if len(ids) == 0 {
+		return NewError("no Discord channel to post to")
+	}
+	var err error
+	for _, id := range ids {
+		multiError(&err, f(id))
+	}
+	return err
+}
+
+func (d *DiscordChatAdapter) send(chanID, msg string) error {
+	if _, err := d.session.ChannelMessageSend(chanID, msg); err != nil {
+		return ErrorWrapf(err, "failed to send Discord message to channel: %s", chanID)
 	}
 	return nil
 }
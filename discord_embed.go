@@ -0,0 +1,85 @@
+package hnoss
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var defaultDiscordEmbedColors = map[string]int{
+	string(ChangeAnnouncement):    0x2ecc71,
+	string(ReplyAnnouncement):     0x3498db,
+	string(ErrorAnnouncement):     0xe74c3c,
+	string(RecoveredAnnouncement): 0x2ecc71,
+	"":                            0x95a5a6,
+}
+
+// Announce posts a to every target channel, as an embed if opts.Embeds is set, unless it's an IP address change and
+// opts.PinStatus is set, then the status message shows it.
+func (d *DiscordChatAdapter) Announce(a *Announcement) error {
+	if d.opts.PinStatus && a.Kind == ChangeAnnouncement {
+		return nil
+	}
+	if !d.opts.Embeds {
+		return d.Post("", a.Message)
+	}
+	embed := d.newEmbed(a)
+	return d.eachTarget(func(id string) error {
+		if _, err := d.session.ChannelMessageSendEmbed(id, embed); err != nil {
+			return ErrorWrapf(err, "failed to send Discord embed to channel: %s", id)
+		}
+		return nil
+	})
+}
+
+// newEmbed makes an embed of a, with the title and colour for its kind, falling back to the defaults, the title in the
+// locale of opts.Catalog.
+func (d *DiscordChatAdapter) newEmbed(a *Announcement) *discordgo.MessageEmbed {
+	kind := string(a.Kind)
+	title, ok := d.opts.EmbedTitles[kind]
+	if !ok {
+		if title = d.opts.Catalog.text(msgEmbedTitlePrefix + kind); title == "" {
+			title = d.opts.Catalog.text(msgEmbedTitle)
+		}
+	}
+	color, ok := d.opts.EmbedColors[kind]
+	if !ok {
+		if color, ok = defaultDiscordEmbedColors[kind]; !ok {
+			color = defaultDiscordEmbedColors[""]
+		}
+	}
+	e := &discordgo.MessageEmbed{
+		Title:       title,
+		Description: a.Message,
+		Color:       color,
+	}
+	if a.IP.IsValid() {
+		e.Fields = append(e.Fields, &discordgo.MessageEmbedField{Name: d.opts.Catalog.text(msgEmbedNewIP),
+			Value: a.IP.String(), Inline: true})
+	}
+	if a.OldIP.IsValid() {
+		e.Fields = append(e.Fields, &discordgo.MessageEmbedField{Name: d.opts.Catalog.text(msgEmbedOldIP),
+			Value: a.OldIP.String(), Inline: true})
+	}
+	if len(d.opts.EmbedPorts) > 0 {
+		ports := make([]string, len(d.opts.EmbedPorts))
+		for i, p := range d.opts.EmbedPorts {
+			ports[i] = strconv.Itoa(p)
+		}
+		e.Fields = append(e.Fields, &discordgo.MessageEmbedField{Name: d.opts.Catalog.text(msgEmbedPorts),
+			Value: strings.Join(ports, ", "), Inline: true})
+	}
+	if !a.Time.Equal(zeroTime) {
+		e.Timestamp = a.Time.Format(time.RFC3339)
+	}
+	footer := d.opts.EmbedFooter
+	if footer == "" {
+		footer = a.Hostname
+	}
+	if footer != "" {
+		e.Footer = &discordgo.MessageEmbedFooter{Text: footer}
+	}
+	return e
+}
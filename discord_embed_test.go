@@ -0,0 +1,65 @@
+package hnoss
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscordEmbeds(t *testing.T) {
+	d, f := newTestDiscordChatAdapter(t, DiscordOptions{
+		ChannelIDs:  []string{"c"},
+		Embeds:      true,
+		EmbedTitles: map[string]string{"change": "Valheim server moved"},
+		EmbedPorts:  []int{2456, 2457},
+	})
+	a := &Announcement{
+		Kind:     ChangeAnnouncement,
+		Message:  "5.6.7.8:2456",
+		IP:       netip.MustParseAddr("5.6.7.8"),
+		OldIP:    netip.MustParseAddr("1.2.3.4"),
+		Time:     newTime(t, "2023-11-28T14:00:00Z"),
+		Hostname: "host",
+	}
+	require.NoError(t, d.Announce(a))
+	req := f.last()
+	assert.Equal(t, "/api/v9/channels/c/messages", req.path)
+	assert.JSONEq(t, `{"embeds":[{"type":"rich","title":"Valheim server moved","description":"5.6.7.8:2456",
+		"timestamp":"2023-11-28T14:00:00Z","color":3066993,"footer":{"text":"host"},"fields":[
+		{"name":"New IP","value":"5.6.7.8","inline":true},{"name":"Old IP","value":"1.2.3.4","inline":true},
+		{"name":"Ports","value":"2456, 2457","inline":true}]}],"tts":false,"components":null}`, string(req.body))
+
+	// Replies to mentions are embeds too, referencing the mention.
+	ev := d.newChatEvent(d.session, &discordgo.Message{
+		ID:        "m",
+		ChannelID: "c",
+		GuildID:   "g",
+		Author:    &discordgo.User{ID: "user"},
+		Content:   "<@bot> ip",
+	})
+	require.NotNil(t, ev.AnnounceReply)
+	require.NoError(t, ev.AnnounceReply(&Announcement{Kind: ReplyAnnouncement, Message: "5.6.7.8:2456"}))
+	var msg discordgo.MessageSend
+	require.NoError(t, json.Unmarshal(f.last().body, &msg))
+	require.Len(t, msg.Embeds, 1)
+	assert.Equal(t, "IP address", msg.Embeds[0].Title)
+	assert.Equal(t, 0x3498db, msg.Embeds[0].Color)
+	assert.Equal(t, "m", msg.Reference.MessageID)
+
+	// Titles and field names are in the locale of the catalog, kinds without a title get the default.
+	d.opts.Catalog = Catalog{"embedTitle.reply": "IP-adresse", "embedNewIP": "Ny IP"}
+	e := d.newEmbed(&Announcement{Kind: ReplyAnnouncement, IP: netip.MustParseAddr("5.6.7.8")})
+	assert.Equal(t, "IP-adresse", e.Title)
+	assert.Equal(t, "Ny IP", e.Fields[0].Name)
+	assert.Equal(t, "hnoss", d.newEmbed(&Announcement{Kind: DigestAnnouncement}).Title)
+
+	// Without embeds plain text is posted.
+	d, f = newTestDiscordChatAdapter(t, DiscordOptions{ChannelIDs: []string{"c"}})
+	require.NoError(t, d.Announce(a))
+	assert.JSONEq(t, `{"content":"5.6.7.8:2456","embeds":null,"tts":false,"components":null}`, string(f.last().body))
+	assert.Nil(t, d.newChatEvent(d.session, &discordgo.Message{Author: &discordgo.User{}}).AnnounceReply)
+}
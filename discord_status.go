@@ -0,0 +1,148 @@
+package hnoss
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// UpdateStatus sets the bot's presence if opts.Presence is set, edits the pinned status message in every target
+// channel, posting and pinning a new one where there is none, if opts.PinStatus is set, and sets the channel topic to
+// the IP address if opts.StatusTopic is set.
+func (d *DiscordChatAdapter) UpdateStatus(s *Status) error {
+	var err error
+	if d.opts.Presence && s.IP.IsValid() {
+		multiError(&err, d.setPresence(fmt.Sprintf(d.opts.PresenceFormat, s.IP.String())))
+	}
+	if !d.opts.PinStatus && !d.opts.StatusTopic {
+		return err
+	}
+	d.statusMu.Lock()
+	defer d.statusMu.Unlock()
+	if lErr := d.loadStatusMessages(); lErr != nil {
+		multiError(&err, lErr)
+		return err
+	}
+	ids := d.targetIDs()
+	if len(ids) == 0 {
+		multiError(&err, NewError("no Discord channel to post status to"))
+		return err
+	}
+	changed := false
+	for _, id := range ids {
+		if d.opts.PinStatus {
+			c, pErr := d.pinStatus(id, d.formatStatus(s))
+			changed = changed || c
+			multiError(&err, pErr)
+		}
+		if d.opts.StatusTopic {
+			multiError(&err, d.setTopic(id, "ip address: "+addrString(s.IP)))
+		}
+	}
+	if changed {
+		multiError(&err, d.saveStatusMessages())
+	}
+	return err
+}
+
+// formatStatus returns the content of status messages, in the locale of opts.Catalog.
+func (d *DiscordChatAdapter) formatStatus(s *Status) string {
+	c := d.opts.Catalog
+	msg := fmt.Sprintf(c.text(msgDiscordStatus), addrString(s.IP), formatTime(s.Changed), formatTime(s.Ran))
+	if s.Err != nil {
+		msg += "\n" + fmt.Sprintf(c.text(msgDiscordFailed), errorMessage(s.Err))
+	}
+	if s.QueueDepth > 0 {
+		msg += "\n" + fmt.Sprintf(c.text(msgQueued), s.QueueDepth)
+	}
+	return msg
+}
+
+// pinStatus edits the status message in chanID, or posts and pins a new one if it doesn't exist, returning whether
+// the message ID changed. statusMu must be held.
+func (d *DiscordChatAdapter) pinStatus(chanID, content string) (bool, error) {
+	if msgID := d.statusMessages[chanID]; msgID != "" {
+		_, err := d.session.ChannelMessageEdit(chanID, msgID, content)
+		var rErr *discordgo.RESTError
+		if err == nil {
+			return false, nil
+		} else if !errors.As(err, &rErr) || rErr.Response.StatusCode != http.StatusNotFound {
+			return false, ErrorWrapf(err, "failed to edit Discord status message in channel: %s", chanID)
+		}
+		// Someone deleted the status message, post a new one.
+	}
+	m, err := d.session.ChannelMessageSend(chanID, content)
+	if err != nil {
+		return false, ErrorWrapf(err, "failed to send Discord status message to channel: %s", chanID)
+	}
+	d.statusMessages[chanID] = m.ID
+	if err = d.session.ChannelMessagePin(chanID, m.ID); err != nil {
+		return true, WarnWrapf(err, "failed to pin Discord status message in channel: %s", chanID)
+	}
+	return true, nil
+}
+
+// setPresence sets the bot's activity to text, if changed.
+func (d *DiscordChatAdapter) setPresence(text string) error {
+	d.presenceMu.Lock()
+	defer d.presenceMu.Unlock()
+	if d.presence == text {
+		return nil
+	}
+	if err := d.updatePresence(text); err != nil {
+		return ErrorWrap(err, "failed to set Discord presence")
+	}
+	d.presence = text
+	return nil
+}
+
+// setTopic sets the topic of chanID, if changed, Discord only allows a channel's topic to be changed twice every 10
+// minutes. statusMu must be held.
+func (d *DiscordChatAdapter) setTopic(chanID, topic string) error {
+	if d.topics[chanID] == topic {
+		return nil
+	}
+	// discordgo.ChannelEdit would also reset the channel's position.
+	endpoint := discordgo.EndpointChannel(chanID)
+	_, err := d.session.RequestWithBucketID(http.MethodPatch, endpoint, map[string]string{"topic": topic}, endpoint)
+	if err != nil {
+		return ErrorWrapf(err, "failed to set Discord channel topic: %s", chanID)
+	}
+	d.topics[chanID] = topic
+	return nil
+}
+
+// loadStatusMessages reads the IDs of status messages, one tab-separated channel and message ID per line.
+func (d *DiscordChatAdapter) loadStatusMessages() error {
+	if d.statusMessages != nil {
+		return nil
+	}
+	messages := map[string]string{}
+	if d.opts.StatusFile != "" {
+		s, err := readStringFile(d.opts.StatusFile, "Discord status")
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(s, "\n") {
+			if chanID, msgID, ok := strings.Cut(line, "\t"); ok {
+				messages[chanID] = msgID
+			}
+		}
+	}
+	d.statusMessages = messages
+	return nil
+}
+
+func (d *DiscordChatAdapter) saveStatusMessages() error {
+	if d.opts.StatusFile == "" {
+		return nil
+	}
+	var b strings.Builder
+	for chanID, msgID := range d.statusMessages {
+		fmt.Fprintf(&b, "%s\t%s\n", chanID, msgID)
+	}
+	return writeStringFile(d.opts.StatusFile, "Discord status", b.String())
+}
@@ -0,0 +1,103 @@
+package hnoss
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscordStatus(t *testing.T) {
+	statusFile := "run/discord-status-test"
+	require.NoError(t, os.RemoveAll(statusFile))
+	opts := DiscordOptions{ChannelIDs: []string{"c"}, PinStatus: true, StatusFile: statusFile, StatusTopic: true}
+	d, f := newTestDiscordChatAdapter(t, opts)
+	f.responses = map[string]string{"/api/v9/channels/c/messages": `{"id":"m1"}`}
+
+	s := &Status{
+		IP:      netip.MustParseAddr("1.2.3.4"),
+		Changed: newTime(t, "2023-11-27T14:00:00Z"),
+		Ran:     newTime(t, "2023-11-28T14:00:00Z"),
+	}
+	require.NoError(t, d.UpdateStatus(s))
+	req := f.requests[0]
+	assert.Equal(t, "/api/v9/channels/c/messages", req.path)
+	var msg struct {
+		Content string `json:"content"`
+	}
+	require.NoError(t, json.Unmarshal(req.body, &msg))
+	assert.Equal(t, "ip address: 1.2.3.4\nlast changed: 2023-11-27T14:00:00Z\nlast checked: 2023-11-28T14:00:00Z",
+		msg.Content)
+	assert.Equal(t, []string{"/api/v9/channels/c/pins/m1"}, f.paths(http.MethodPut))
+	req = f.last()
+	assert.Equal(t, http.MethodPatch, req.method)
+	assert.Equal(t, "/api/v9/channels/c", req.path)
+	assert.JSONEq(t, `{"topic":"ip address: 1.2.3.4"}`, string(req.body))
+	b, err := os.ReadFile(statusFile)
+	require.NoError(t, err)
+	assert.Equal(t, "c\tm1\n", string(b))
+
+	// The topic is only set when it changes.
+	s.Err = NewError("An error")
+	require.NoError(t, d.UpdateStatus(s))
+	assert.Equal(t, []string{"/api/v9/channels/c", "/api/v9/channels/c/messages/m1"}, f.paths(http.MethodPatch))
+	require.NoError(t, json.Unmarshal(f.last().body, &msg))
+	assert.Contains(t, msg.Content, "\nlast check failed: An error")
+
+	// After a restart the status message is edited, or replaced if deleted.
+	d, f = newTestDiscordChatAdapter(t, opts)
+	f.responses = map[string]string{
+		"/api/v9/channels/c/messages":    `{"id":"m2"}`,
+		"/api/v9/channels/c/messages/m1": "",
+	}
+	s.Err = nil
+	require.NoError(t, d.UpdateStatus(s))
+	assert.Equal(t, []string{"/api/v9/channels/c/messages"}, f.paths(http.MethodPost))
+	assert.Equal(t, []string{"/api/v9/channels/c/pins/m2"}, f.paths(http.MethodPut))
+	b, err = os.ReadFile(statusFile)
+	require.NoError(t, err)
+	assert.Equal(t, "c\tm2\n", string(b))
+
+	// Changes are shown by the status message rather than posted, errors are still posted.
+	require.NoError(t, d.Announce(&Announcement{Kind: ChangeAnnouncement, Message: "1.2.3.4"}))
+	assert.Len(t, f.paths(http.MethodPost), 1)
+	require.NoError(t, d.Announce(&Announcement{Kind: ErrorAnnouncement, Message: "failed"}))
+	assert.Len(t, f.paths(http.MethodPost), 2)
+
+	// In the locale of the catalog.
+	catalogs, err := loadCatalogs("locales")
+	require.NoError(t, err)
+	d.opts.Catalog = catalogs["nb"]
+	s.Err = NewError("An error")
+	s.QueueDepth = 2
+	assert.Equal(t, "IP-adresse: 1.2.3.4\nsist endret: 2023-11-27T14:00:00Z\nsist sjekket: 2023-11-28T14:00:00Z\n"+
+		"siste sjekk feilet: An error\nkunngjøringer i kø: 2", d.formatStatus(s))
+}
+
+func TestDiscordPresence(t *testing.T) {
+	d, _ := newTestDiscordChatAdapter(t, DiscordOptions{Presence: true, PresenceFormat: "%s:2456"})
+	var presences []string
+	d.updatePresence = func(text string) error {
+		presences = append(presences, text)
+		return nil
+	}
+
+	// Nothing to show until the IP address is known.
+	require.NoError(t, d.UpdateStatus(&Status{}))
+	assert.Empty(t, presences)
+
+	require.NoError(t, d.UpdateStatus(&Status{IP: netip.MustParseAddr("1.2.3.4")}))
+	require.NoError(t, d.UpdateStatus(&Status{IP: netip.MustParseAddr("1.2.3.4")}))
+	require.NoError(t, d.UpdateStatus(&Status{IP: netip.MustParseAddr("5.6.7.8")}))
+	assert.Equal(t, []string{"1.2.3.4:2456", "5.6.7.8:2456"}, presences)
+
+	// Re-applied after reconnecting.
+	d.ready(d.session, &discordgo.Ready{})
+	assert.NoError(t, d.readyErr)
+	assert.Equal(t, []string{"1.2.3.4:2456", "5.6.7.8:2456", "5.6.7.8:2456"}, presences)
+}
@@ -0,0 +1,351 @@
+package hnoss
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	// fakeDiscordREST stands in for the Discord REST API, recording requests.
+	fakeDiscordREST struct {
+		*httptest.Server
+		mu       sync.Mutex
+		requests []*fakeDiscordRequest
+		// responses maps request paths to response bodies, a body of "" responds 404 Not Found.
+		responses map[string]string
+	}
+	fakeDiscordRequest struct {
+		method, path string
+		body         []byte
+	}
+	// rewriteTransport sends all requests to url.
+	rewriteTransport struct {
+		url *url.URL
+	}
+)
+
+func newFakeDiscordREST(t *testing.T) *fakeDiscordREST {
+	f := &fakeDiscordREST{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		f.mu.Lock()
+		f.requests = append(f.requests, &fakeDiscordRequest{method: r.Method, path: r.URL.Path, body: body})
+		res, ok := f.responses[r.URL.Path]
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if ok {
+			if res == "" {
+				w.WriteHeader(http.StatusNotFound)
+				res = `{"message":"Unknown Channel","code":10003}`
+			}
+			_, _ = w.Write([]byte(res))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/commands") {
+			_, _ = w.Write([]byte("[]"))
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// paths returns the paths of requests made with method.
+func (f *fakeDiscordREST) paths(method string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var paths []string
+	for _, r := range f.requests {
+		if r.method == method {
+			paths = append(paths, r.path)
+		}
+	}
+	return paths
+}
+
+func (f *fakeDiscordREST) last() *fakeDiscordRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		return nil
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+func (t *rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Scheme = t.url.Scheme
+	r.URL.Host = t.url.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func newTestDiscordChatAdapter(t *testing.T, opts DiscordOptions) (*DiscordChatAdapter, *fakeDiscordREST) {
+	f := newFakeDiscordREST(t)
+	u, err := url.Parse(f.URL)
+	require.NoError(t, err)
+	d := NewDiscordChatAdapter("1234", "valheim", opts)
+	d.session.Client = &http.Client{Transport: &rewriteTransport{url: u}}
+	d.session.State.User = &discordgo.User{ID: "bot"}
+	return d, f
+}
+
+func TestDiscordSlashCommands(t *testing.T) {
+	d, f := newTestDiscordChatAdapter(t, DiscordOptions{SlashCommands: true, Ephemeral: true, Catalogs: map[string]Catalog{
+		"en": defaultCatalog,
+		"nb": {"command.ip": "Vis IP-adressen", "command.history.n": "Antall endringer å vise"},
+	}})
+
+	d.SetCommands(append(append(builtinCommands, subscriptionCommands...),
+		&Command{Name: "ping", Usage: "[host]", Description: "Check the bot is listening"}))
+	d.ready(d.session, &discordgo.Ready{Application: &discordgo.Application{ID: "app"}})
+	assert.NoError(t, d.readyErr)
+	req := f.last()
+	require.NotNil(t, req)
+	assert.Equal(t, http.MethodPut, req.method)
+	assert.Equal(t, "/api/v9/applications/app/commands", req.path)
+	var cmds []*discordgo.ApplicationCommand
+	require.NoError(t, json.Unmarshal(req.body, &cmds))
+	names := make([]string, len(cmds))
+	for i, c := range cmds {
+		names[i] = c.Name
+	}
+	assert.Equal(t, []string{"ip", "refresh", "status", "history", "uptime", "help", "subscribe",
+		"unsubscribe", "format", "subscriptions", "ping"}, names)
+	// Described in English, translated for the locales Discord supports.
+	assert.Equal(t, "Show the IP address", cmds[0].Description)
+	assert.Equal(t, &map[discordgo.Locale]string{discordgo.Norwegian: "Vis IP-adressen"},
+		cmds[0].DescriptionLocalizations)
+	assert.Nil(t, cmds[1].DescriptionLocalizations)
+	assert.Equal(t, "Number of changes to show", cmds[3].Options[0].Description)
+	assert.Equal(t, map[discordgo.Locale]string{discordgo.Norwegian: "Antall endringer å vise"},
+		cmds[3].Options[0].DescriptionLocalizations)
+	// Commands registered elsewhere are described by the command, taking their arguments as one option.
+	assert.Equal(t, "Check the bot is listening", cmds[10].Description)
+	assert.Equal(t, []*discordgo.ApplicationCommandOption{{Type: discordgo.ApplicationCommandOptionString,
+		Name: "args", Description: "[host]"}}, cmds[10].Options)
+
+	evs := make(chan *ChatEvent)
+	go func() {
+		evs <- <-d.Chan()
+	}()
+	d.interactionCreate(d.session, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        "int",
+		AppID:     "app",
+		Type:      discordgo.InteractionApplicationCommand,
+		ChannelID: "chan",
+		GuildID:   "guild",
+		Token:     "token",
+		Member:    &discordgo.Member{User: &discordgo.User{ID: "user", Username: "name", Discriminator: "0001"}},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name: "history",
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "n", Type: discordgo.ApplicationCommandOptionInteger, Value: 5.0},
+			},
+		},
+	}})
+	ev := <-evs
+	req = f.last()
+	assert.Equal(t, "/api/v9/interactions/int/token/callback", req.path)
+	var res discordgo.InteractionResponse
+	require.NoError(t, json.Unmarshal(req.body, &res))
+	assert.Equal(t, discordgo.InteractionResponseDeferredChannelMessageWithSource, res.Type)
+	assert.Equal(t, discordgo.MessageFlagsEphemeral, res.Data.Flags)
+
+	assert.Equal(t, "chan", ev.ChanID)
+	assert.Equal(t, "guild", ev.GuildID)
+	assert.Equal(t, "user", ev.AuthorID)
+	assert.Equal(t, "name#0001", ev.AuthorName)
+	assert.Equal(t, "/history 5", ev.Text)
+	assert.Equal(t, "history", ev.Command)
+	assert.Equal(t, []string{"5"}, ev.Args)
+
+	require.NoError(t, ev.Reply("1.2.3.4"))
+	req = f.last()
+	assert.Equal(t, http.MethodPatch, req.method)
+	assert.Equal(t, "/api/v9/webhooks/app/token/messages/@original", req.path)
+	assert.JSONEq(t, `{"content":"1.2.3.4"}`, string(req.body))
+
+	require.NoError(t, ev.Dismiss())
+	req = f.last()
+	assert.Equal(t, http.MethodDelete, req.method)
+	assert.Equal(t, "/api/v9/webhooks/app/token/messages/@original", req.path)
+
+	require.NoError(t, d.Close())
+	req = f.last()
+	assert.Equal(t, http.MethodPut, req.method)
+	assert.Equal(t, "/api/v9/applications/app/commands", req.path)
+	assert.JSONEq(t, "[]", string(req.body))
+}
+
+func TestDiscordNewChatEvent(t *testing.T) {
+	d, _ := newTestDiscordChatAdapter(t, DiscordOptions{})
+	ev := d.newChatEvent(d.session, &discordgo.Message{
+		ChannelID: "chan",
+		GuildID:   "guild",
+		Author:    &discordgo.User{ID: "user", Username: "name", Discriminator: "0001"},
+		Content:   "<@!bot> History 3",
+	})
+	assert.Equal(t, "user", ev.AuthorID)
+	assert.Equal(t, "<@!bot> History 3", ev.Text)
+	assert.Equal(t, "history", ev.Command)
+	assert.Equal(t, []string{"3"}, ev.Args)
+}
+
+func TestDiscordReplyDirect(t *testing.T) {
+	d, f := newTestDiscordChatAdapter(t, DiscordOptions{})
+	f.responses = map[string]string{"/api/v9/users/@me/channels": `{"id":"dm"}`}
+	ev := d.newChatEvent(d.session, &discordgo.Message{
+		ChannelID: "chan",
+		GuildID:   "guild",
+		Author:    &discordgo.User{ID: "user"},
+		Member:    &discordgo.Member{Roles: []string{"role"}},
+		Content:   "<@bot> ip",
+	})
+	assert.Equal(t, []string{"role"}, ev.RoleIDs)
+	require.NoError(t, ev.ReplyDirect("1.2.3.4"))
+	assert.JSONEq(t, `{"recipient_id":"user"}`, string(f.requests[0].body))
+	assert.Equal(t, "/api/v9/channels/dm/messages", f.last().path)
+}
+
+func TestDiscordTargets(t *testing.T) {
+	d, f := newTestDiscordChatAdapter(t, DiscordOptions{
+		ChannelIDs:    []string{"explicit"},
+		GuildChannels: map[string][]string{"g2": {"announcements", "g2id"}},
+	})
+	f.responses = map[string]string{
+		"/api/v9/guilds/g1/channels": `[{"id":"g1valheim","name":"valheim","type":0},
+			{"id":"g1voice","name":"valheim","type":2},{"id":"g1other","name":"other","type":0}]`,
+		"/api/v9/channels/explicit/messages": "",
+	}
+
+	d.ready(d.session, &discordgo.Ready{Guilds: []*discordgo.Guild{{ID: "g1"}}})
+	assert.Equal(t, []string{"explicit", "g1valheim"}, d.targetIDs())
+
+	// A guild joined after Ready, with channels matched by name, configured name and configured ID.
+	d.guildCreate(d.session, &discordgo.GuildCreate{Guild: &discordgo.Guild{ID: "g2", Channels: []*discordgo.Channel{
+		{ID: "g2valheim", Name: "valheim", Type: discordgo.ChannelTypeGuildText},
+		{ID: "g2announcements", Name: "announcements", Type: discordgo.ChannelTypeGuildText},
+		{ID: "g2id", Name: "general", Type: discordgo.ChannelTypeGuildText},
+		{ID: "g2other", Name: "other", Type: discordgo.ChannelTypeGuildText},
+	}}})
+	assert.Equal(t, []string{"explicit", "g1valheim", "g2announcements", "g2id", "g2valheim"}, d.targetIDs())
+
+	// Posting continues past a channel that fails.
+	err := d.Post("", "1.2.3.4")
+	assert.ErrorContains(t, err, "failed to send Discord message to channel: explicit")
+	assert.Equal(t, []string{
+		"/api/v9/channels/explicit/messages",
+		"/api/v9/channels/g1valheim/messages",
+		"/api/v9/channels/g2announcements/messages",
+		"/api/v9/channels/g2id/messages",
+		"/api/v9/channels/g2valheim/messages",
+	}, f.paths(http.MethodPost))
+
+	d.guildDelete(d.session, &discordgo.GuildDelete{Guild: &discordgo.Guild{ID: "g1"}})
+	assert.Equal(t, []string{"explicit", "g2announcements", "g2id", "g2valheim"}, d.targetIDs())
+
+	d, _ = newTestDiscordChatAdapter(t, DiscordOptions{})
+	assert.EqualError(t, d.Post("", "1.2.3.4"), "ERROR: no Discord channel to post to")
+}
+
+// newFakeDiscordGateway returns the URL of a gateway that says hello, then sends Ready after identify if ready is set,
+// counting connections.
+func newFakeDiscordGateway(t *testing.T, ready bool, conns *atomic.Int32) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conns.Add(1)
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.WriteJSON(map[string]any{"op": 10, "d": map[string]int{"heartbeat_interval": 45000}}))
+		if _, _, err = conn.ReadMessage(); err != nil {
+			return
+		}
+		if ready {
+			err = conn.WriteJSON(map[string]any{"op": 0, "t": "READY", "s": 1,
+				"d": map[string]any{"session_id": "s", "user": map[string]string{"id": "bot"}}})
+			require.NoError(t, err)
+		}
+		// Hold the connection open until the client closes it.
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestDiscordListen(t *testing.T) {
+	backoff := discordBackoff
+	discordBackoff = time.Millisecond
+	t.Cleanup(func() { discordBackoff = backoff })
+	var buf bytes.Buffer
+	logger := &Logger{logger: log.New(&buf, "", 0)}
+
+	// Ready never comes.
+	var conns atomic.Int32
+	d, f := newTestDiscordChatAdapter(t, DiscordOptions{
+		ReadyTimeout:    100 * time.Millisecond,
+		ConnectAttempts: 2,
+		Logger:          logger,
+	})
+	f.responses = map[string]string{"/api/v9/gateway": `{"url":"` + newFakeDiscordGateway(t, false, &conns) + `"}`}
+	err := d.Listen()
+	var e *Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, "ERROR: timed out after 100ms waiting for Discord to be ready", err.Error())
+	assert.Equal(t, int32(2), conns.Load())
+	assert.Equal(t, discordDisconnected, d.getState())
+	assert.Equal(t, "WARN: Discord connection attempt 1 failed, retrying in 1ms: "+
+		"ERROR: timed out after 100ms waiting for Discord to be ready\n", buf.String())
+
+	// Ready comes.
+	buf.Reset()
+	d, f = newTestDiscordChatAdapter(t, DiscordOptions{ReadyTimeout: time.Second, Logger: logger})
+	f.responses = map[string]string{"/api/v9/gateway": `{"url":"` + newFakeDiscordGateway(t, true, &conns) + `"}`}
+	err = d.Listen()
+	var i *Info
+	require.ErrorAs(t, err, &i)
+	assert.Equal(t, "INFO: connected to Discord", err.Error())
+	err = d.Listen()
+	var w *Warn
+	require.ErrorAs(t, err, &w)
+	assert.Equal(t, "WARN: Discord already connected", err.Error())
+
+	// Repeated Ready events are harmless.
+	d.ready(d.session, &discordgo.Ready{})
+	d.resumed(d.session, &discordgo.Resumed{})
+	assert.Equal(t, discordConnected, d.getState())
+	assert.Empty(t, buf.String())
+
+	// Losing the connection is left to discordgo to recover from.
+	d.disconnect(d.session, &discordgo.Disconnect{})
+	assert.Equal(t, discordReconnecting, d.getState())
+	err = d.Listen()
+	require.ErrorAs(t, err, &w)
+	assert.Equal(t, "WARN: Discord reconnecting", err.Error())
+	d.resumed(d.session, &discordgo.Resumed{})
+	assert.Equal(t, discordConnected, d.getState())
+	assert.Equal(t, "WARN: lost connection to Discord, reconnecting\nINFO: resumed Discord session\n", buf.String())
+
+	require.NoError(t, d.Close())
+	assert.Equal(t, discordDisconnected, d.getState())
+	assert.Equal(t, "WARN: lost connection to Discord, reconnecting\nINFO: resumed Discord session\n", buf.String())
+}
@@ -0,0 +1,116 @@
+package hnoss
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type (
+	// DiscordWebhookChatAdapter posts through a Discord webhook, without a bot gateway connection. It's post-only,
+	// Chan never fires.
+	DiscordWebhookChatAdapter struct {
+		webhookID string
+		token     string
+		opts      DiscordWebhookOptions
+
+		messageID string
+		loaded    bool
+		c         chan *ChatEvent
+		session   *discordgo.Session
+	}
+	// DiscordWebhookOptions configures optional DiscordWebhookChatAdapter behaviour.
+	DiscordWebhookOptions struct {
+		// Username and AvatarURL override the webhook's defaults.
+		Username  string
+		AvatarURL string
+		// EditPrevious edits the last message posted instead of posting a new one, a new message is posted if the
+		// last one was deleted.
+		EditPrevious bool
+		// MessageFile persists the ID of the last message posted, so it can be edited after a restart.
+		MessageFile string
+	}
+)
+
+// NewDiscordWebhookChatAdapter takes a webhook URL of the form https://discord.com/api/webhooks/<id>/<token>.
+func NewDiscordWebhookChatAdapter(webhookURL string, opts DiscordWebhookOptions) (*DiscordWebhookChatAdapter, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, FatalWrap(err, "config: failed to parse Discord webhook URL")
+	}
+	_, idToken, ok := strings.Cut(u.Path, "/webhooks/")
+	id, token, _ := strings.Cut(idToken, "/")
+	if !ok || id == "" || token == "" {
+		return nil, Fatalf("config: Discord webhook URL must be of the form %s",
+			"https://discord.com/api/webhooks/<id>/<token>")
+	}
+	// New never actually returns an error
+	session, _ := discordgo.New("")
+	return &DiscordWebhookChatAdapter{
+		webhookID: id,
+		token:     token,
+		opts:      opts,
+		c:         make(chan *ChatEvent),
+		session:   session,
+	}, nil
+}
+
+func (d *DiscordWebhookChatAdapter) Chan() <-chan *ChatEvent {
+	return d.c
+}
+
+func (d *DiscordWebhookChatAdapter) Listen() error {
+	return nil
+}
+
+func (d *DiscordWebhookChatAdapter) Close() error {
+	return nil
+}
+
+// Post msg to the webhook's channel, chanID is ignored.
+func (d *DiscordWebhookChatAdapter) Post(_, msg string) error {
+	if d.opts.EditPrevious {
+		if err := d.loadMessageID(); err != nil {
+			return err
+		}
+		if d.messageID != "" {
+			_, err := d.session.WebhookMessageEdit(d.webhookID, d.token, d.messageID, &discordgo.WebhookEdit{
+				Content: &msg,
+			})
+			var rErr *discordgo.RESTError
+			if err == nil {
+				return nil
+			} else if !errors.As(err, &rErr) || rErr.Response.StatusCode != http.StatusNotFound {
+				return ErrorWrap(err, "failed to edit Discord webhook message")
+			}
+			// Someone deleted the previous message, post a new one.
+		}
+	}
+	m, err := d.session.WebhookExecute(d.webhookID, d.token, true, &discordgo.WebhookParams{
+		Content:   msg,
+		Username:  d.opts.Username,
+		AvatarURL: d.opts.AvatarURL,
+	})
+	if err != nil {
+		return ErrorWrap(err, "failed to execute Discord webhook")
+	}
+	if d.opts.EditPrevious {
+		d.messageID = m.ID
+		if d.opts.MessageFile != "" {
+			return writeStringFile(d.opts.MessageFile, "Discord webhook message", m.ID)
+		}
+	}
+	return nil
+}
+
+func (d *DiscordWebhookChatAdapter) loadMessageID() (err error) {
+	if d.loaded || d.opts.MessageFile == "" {
+		return nil
+	}
+	d.messageID, err = readStringFile(d.opts.MessageFile, "Discord webhook message")
+	d.loaded = err == nil
+	return
+}
@@ -0,0 +1,81 @@
+package hnoss
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscordWebhookChatAdapter(t *testing.T) {
+	require.NoError(t, os.RemoveAll("run/discord-webhook-message"))
+	var requests []*fakeDiscordRequest
+	deleted := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		requests = append(requests, &fakeDiscordRequest{method: r.Method, path: r.URL.Path, body: body})
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPatch && deleted {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"code":10008,"message":"Unknown Message"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"m` + string(rune('0'+len(requests))) + `"}`))
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	_, err = NewDiscordWebhookChatAdapter("https://discord.com/api/channels/1", DiscordWebhookOptions{})
+	var fatal *Fatal
+	assert.ErrorAs(t, err, &fatal)
+
+	newAdapter := func() *DiscordWebhookChatAdapter {
+		d, err := NewDiscordWebhookChatAdapter("https://discord.com/api/webhooks/id/token", DiscordWebhookOptions{
+			Username:     "hnoss",
+			AvatarURL:    "https://example.org/hnoss.png",
+			EditPrevious: true,
+			MessageFile:  "run/discord-webhook-message",
+		})
+		require.NoError(t, err)
+		d.session.Client = &http.Client{Transport: &rewriteTransport{url: u}}
+		return d
+	}
+	d := newAdapter()
+	assert.NoError(t, d.Listen())
+
+	require.NoError(t, d.Post("", "1.2.3.4"))
+	require.Len(t, requests, 1)
+	assert.Equal(t, http.MethodPost, requests[0].method)
+	assert.Equal(t, "/api/v9/webhooks/id/token", requests[0].path)
+	var params map[string]any
+	require.NoError(t, json.Unmarshal(requests[0].body, &params))
+	assert.Equal(t, "1.2.3.4", params["content"])
+	assert.Equal(t, "hnoss", params["username"])
+	assert.Equal(t, "https://example.org/hnoss.png", params["avatar_url"])
+
+	// Edit persisted message after restart.
+	d = newAdapter()
+	require.NoError(t, d.Post("", "5.6.7.8"))
+	require.Len(t, requests, 2)
+	assert.Equal(t, http.MethodPatch, requests[1].method)
+	assert.Equal(t, "/api/v9/webhooks/id/token/messages/m1", requests[1].path)
+	assert.JSONEq(t, `{"content":"5.6.7.8"}`, string(requests[1].body))
+
+	// Post a new message when the previous one was deleted.
+	deleted = true
+	require.NoError(t, d.Post("", "9.10.11.12"))
+	require.Len(t, requests, 4)
+	assert.Equal(t, http.MethodPost, requests[3].method)
+	b, err := os.ReadFile("run/discord-webhook-message")
+	require.NoError(t, err)
+	assert.Equal(t, "m4", string(b))
+	assert.NoError(t, d.Close())
+}
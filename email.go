@@ -0,0 +1,240 @@
+package hnoss
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type (
+	// EmailChatAdapter sends announcements by SMTP. It's post-only, Chan never fires.
+	EmailChatAdapter struct {
+		addr string
+		from string
+		to   []string
+		opts EmailOptions
+
+		subject, body *template.Template
+		tlsConfig     *tls.Config
+		c             chan *ChatEvent
+	}
+	// EmailOptions configures optional EmailChatAdapter behaviour.
+	EmailOptions struct {
+		// Security is one of "starttls", the default, "tls" for implicit TLS, or "none".
+		Security string
+		// Auth is one of "plain", the default, or "login", used if Username is set.
+		Auth     string
+		Username string
+		Password string
+		// SubjectTemplate and BodyTemplate are text/templates executed with an Announcement.
+		SubjectTemplate string
+		BodyTemplate    string
+	}
+	loginAuth struct {
+		username, password string
+	}
+)
+
+const (
+	defaultEmailSubject = "hnoss: {{.Message}}"
+	defaultEmailBody    = `{{.Message}}
+{{if .OldIP.IsValid}}
+Previous IP address: {{.OldIP}}{{end}}
+Time: {{.Time.Format "2006-01-02T15:04:05Z07:00"}}
+Host: {{.Hostname}}
+`
+)
+
+// NewEmailChatAdapter takes the SMTP server addr as host:port.
+func NewEmailChatAdapter(addr, from string, to []string, opts EmailOptions) (*EmailChatAdapter, error) {
+	if opts.Security == "" {
+		opts.Security = "starttls"
+	}
+	if opts.Auth == "" {
+		opts.Auth = "plain"
+	}
+	if opts.SubjectTemplate == "" {
+		opts.SubjectTemplate = defaultEmailSubject
+	}
+	if opts.BodyTemplate == "" {
+		opts.BodyTemplate = defaultEmailBody
+	}
+	switch opts.Security {
+	case "starttls", "tls", "none":
+	default:
+		return nil, Fatalf("config: unknown email security: %s", opts.Security)
+	}
+	switch opts.Auth {
+	case "plain", "login":
+	default:
+		return nil, Fatalf("config: unknown email auth: %s", opts.Auth)
+	}
+	if len(to) == 0 {
+		return nil, NewFatal("config: no email recipients")
+	}
+	subject, err := template.New("subject").Parse(opts.SubjectTemplate)
+	if err != nil {
+		return nil, FatalWrap(err, "config: failed to parse email subject template")
+	}
+	body, err := template.New("body").Parse(opts.BodyTemplate)
+	if err != nil {
+		return nil, FatalWrap(err, "config: failed to parse email body template")
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	return &EmailChatAdapter{
+		addr:      addr,
+		from:      from,
+		to:        to,
+		opts:      opts,
+		subject:   subject,
+		body:      body,
+		tlsConfig: &tls.Config{ServerName: host},
+		c:         make(chan *ChatEvent),
+	}, nil
+}
+
+func (e *EmailChatAdapter) Chan() <-chan *ChatEvent {
+	return e.c
+}
+
+func (e *EmailChatAdapter) Listen() error {
+	return nil
+}
+
+func (e *EmailChatAdapter) Close() error {
+	return nil
+}
+
+// Post msg to all recipients, chanID is ignored.
+func (e *EmailChatAdapter) Post(_, msg string) error {
+	hostname, _ := os.Hostname()
+	return e.Announce(&Announcement{Message: msg, Time: time.Now().UTC(), Hostname: hostname})
+}
+
+func (e *EmailChatAdapter) Announce(a *Announcement) error {
+	var subject, body bytes.Buffer
+	if err := e.subject.Execute(&subject, a); err != nil {
+		return ErrorWrap(err, "failed to execute email subject template")
+	}
+	if err := e.body.Execute(&body, a); err != nil {
+		return ErrorWrap(err, "failed to execute email body template")
+	}
+
+	c, err := e.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err = c.Mail(e.from); err != nil {
+		return ErrorWrap(err, "SMTP MAIL failed")
+	}
+	for _, to := range e.to {
+		if err = c.Rcpt(to); err != nil {
+			return ErrorWrapf(err, "SMTP RCPT failed for: %s", to)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return ErrorWrap(err, "SMTP DATA failed")
+	}
+	if _, err = w.Write(e.message(a, strings.TrimSpace(subject.String()), body.String())); err != nil {
+		return ErrorWrap(err, "failed to write email")
+	}
+	if err = w.Close(); err != nil {
+		return ErrorWrap(err, "failed to send email")
+	}
+	if err = c.Quit(); err != nil {
+		return ErrorWrap(err, "SMTP QUIT failed")
+	}
+	return nil
+}
+
+// dial connects, secures and authenticates an SMTP client.
+func (e *EmailChatAdapter) dial() (*smtp.Client, error) {
+	var conn net.Conn
+	var err error
+	if e.opts.Security == "tls" {
+		conn, err = tls.Dial("tcp", e.addr, e.tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", e.addr)
+	}
+	if err != nil {
+		return nil, ErrorWrapf(err, "failed to connect to SMTP server: %s", e.addr)
+	}
+	c, err := smtp.NewClient(conn, e.tlsConfig.ServerName)
+	if err != nil {
+		_ = conn.Close()
+		return nil, ErrorWrap(err, "failed to start SMTP session")
+	}
+	if e.opts.Security == "starttls" {
+		if err = c.StartTLS(e.tlsConfig); err != nil {
+			_ = c.Close()
+			return nil, ErrorWrap(err, "SMTP STARTTLS failed")
+		}
+	}
+	if e.opts.Username != "" {
+		var auth smtp.Auth
+		if e.opts.Auth == "login" {
+			auth = &loginAuth{e.opts.Username, e.opts.Password}
+		} else {
+			auth = smtp.PlainAuth("", e.opts.Username, e.opts.Password, e.tlsConfig.ServerName)
+		}
+		if err = c.Auth(auth); err != nil {
+			_ = c.Close()
+			return nil, ErrorWrap(err, "SMTP AUTH failed")
+		}
+	}
+	return c, nil
+}
+
+func (e *EmailChatAdapter) message(a *Announcement, subject, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", encodeSubject(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// encodeSubject makes subject safe for a header, replacing line breaks, which could inject headers, with spaces and
+// encoding any non-ASCII text.
+func encodeSubject(subject string) string {
+	subject = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(subject)
+	return mime.QEncoding.Encode("utf-8", subject)
+}
+
+// Start implements smtp.Auth for AUTH LOGIN, which like smtp.PlainAuth is only allowed over TLS or to localhost.
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, NewError("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSuffix(string(fromServer), ":")) {
+	case "username":
+		return []byte(a.username), nil
+	case "password":
+		return []byte(a.password), nil
+	default:
+		return nil, Errorf("unexpected AUTH LOGIN challenge: %s", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,198 @@
+package hnoss
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	// fakeSMTPServer is an SMTP sink, recording the envelope and data of each message.
+	fakeSMTPServer struct {
+		net.Listener
+		tlsConfig *tls.Config
+		mails     chan *fakeMail
+	}
+	fakeMail struct {
+		auth, from string
+		to         []string
+		data       string
+	}
+)
+
+func newFakeSMTPServer(t *testing.T, tlsConfig *tls.Config, implicitTLS bool) *fakeSMTPServer {
+	var l net.Listener
+	var err error
+	if implicitTLS {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	require.NoError(t, err)
+	s := &fakeSMTPServer{Listener: l, tlsConfig: tlsConfig, mails: make(chan *fakeMail, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	return s
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	_, isTLS := conn.(*tls.Conn)
+	mail := &fakeMail{}
+	_ = tp.PrintfLine("220 smtp.test ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			ext := []string{"250-smtp.test", "250-AUTH PLAIN LOGIN"}
+			if !isTLS && s.tlsConfig != nil {
+				ext = append(ext, "250-STARTTLS")
+			}
+			for _, e := range ext {
+				_ = tp.PrintfLine("%s", e)
+			}
+			_ = tp.PrintfLine("250 8BITMIME")
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 go ahead")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, isTLS = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			mech, initial, _ := strings.Cut(arg, " ")
+			if mech == "LOGIN" {
+				_ = tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				user, _ := tp.ReadLine()
+				_ = tp.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				pass, _ := tp.ReadLine()
+				u, _ := base64.StdEncoding.DecodeString(user)
+				p, _ := base64.StdEncoding.DecodeString(pass)
+				mail.auth = "LOGIN " + string(u) + ":" + string(p)
+			} else {
+				b, _ := base64.StdEncoding.DecodeString(initial)
+				mail.auth = "PLAIN " + strings.ReplaceAll(string(b), "\x00", ":")
+			}
+			_ = tp.PrintfLine("235 ok")
+		case "MAIL":
+			mail.from, _, _ = strings.Cut(strings.TrimPrefix(arg, "FROM:<"), ">")
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			mail.to = append(mail.to, strings.TrimSuffix(strings.TrimPrefix(arg, "TO:<"), ">"))
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			b, _ := tp.ReadDotBytes()
+			mail.data = string(b)
+			s.mails <- mail
+			_ = tp.PrintfLine("250 ok")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func TestEmailChatAdapter(t *testing.T) {
+	// Borrow httptest's certificate for 127.0.0.1.
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+	serverTLS := &tls.Config{Certificates: ts.TLS.Certificates}
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	ti, err := time.Parse(time.RFC3339, "2023-11-28T00:00:00Z")
+	require.NoError(t, err)
+	a := &Announcement{
+		Message:  "5.6.7.8",
+		IP:       netip.MustParseAddr("5.6.7.8"),
+		OldIP:    netip.MustParseAddr("1.2.3.4"),
+		Time:     ti,
+		Hostname: "host",
+	}
+	to := []string{"a@example.org", "b@example.org"}
+
+	testCases := []struct {
+		description string
+		implicitTLS bool
+		opts        EmailOptions
+		xAuth       string
+	}{
+		{"STARTTLS", false, EmailOptions{Username: "user", Password: "pass"}, "PLAIN :user:pass"},
+		{"TLS", true, EmailOptions{Security: "tls", Auth: "login", Username: "user", Password: "pass"},
+			"LOGIN user:pass"},
+		{"None", false, EmailOptions{Security: "none"}, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			s := newFakeSMTPServer(t, serverTLS, tc.implicitTLS)
+			e, err := NewEmailChatAdapter(s.Addr().String(), "hnoss@example.org", to, tc.opts)
+			require.NoError(t, err)
+			e.tlsConfig.RootCAs = roots
+			assert.NoError(t, e.Listen())
+
+			require.NoError(t, e.Announce(a))
+			mail := <-s.mails
+			assert.Equal(t, tc.xAuth, mail.auth)
+			assert.Equal(t, "hnoss@example.org", mail.from)
+			assert.Equal(t, to, mail.to)
+			assert.Contains(t, mail.data, "Subject: hnoss: 5.6.7.8\n")
+			assert.Contains(t, mail.data, "To: a@example.org, b@example.org\n")
+			assert.Contains(t, mail.data, "5.6.7.8\n\nPrevious IP address: 1.2.3.4\nTime: 2023-11-28T00:00:00Z\n"+
+				"Host: host\n")
+			assert.NoError(t, e.Close())
+		})
+	}
+
+	s := newFakeSMTPServer(t, nil, false)
+	e, err := NewEmailChatAdapter(s.Addr().String(), "hnoss@example.org", to, EmailOptions{
+		Security:        "none",
+		SubjectTemplate: "IP {{.IP}}",
+		BodyTemplate:    "{{.Message}} on {{.Hostname}}",
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.Post("", "hello"))
+	mail := <-s.mails
+	assert.Contains(t, mail.data, "Subject: IP invalid IP\n")
+	hostname, _ := os.Hostname()
+	assert.True(t, strings.HasSuffix(mail.data, "\nhello on "+hostname+"\n"))
+
+	// Line breaks can't inject headers, non-ASCII text is encoded.
+	assert.Equal(t, "hnoss: 1.2.3.4 Bcc: x@example.org", encodeSubject("hnoss: 1.2.3.4\r\nBcc: x@example.org"))
+	assert.Equal(t, "=?utf-8?q?IP-adresse_endret_p=C3=A5_v=C3=A6rt?=", encodeSubject("IP-adresse endret på vært"))
+
+	_, err = NewEmailChatAdapter(s.Addr().String(), "hnoss@example.org", nil, EmailOptions{})
+	var fatal *Fatal
+	assert.ErrorAs(t, err, &fatal)
+	_, err = NewEmailChatAdapter(s.Addr().String(), "hnoss@example.org", to, EmailOptions{Security: "ssl"})
+	assert.ErrorAs(t, err, &fatal)
+}
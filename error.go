@@ -17,3 +17,27 @@ func multiError(existErr *error, newErr error) bool {
 	*existErr = newErr
 	return true
 }
+
+// errorMessage returns the message of err without its level prefix, for display to chat users.
+func errorMessage(err error) string {
+	switch err.(type) {
+	case *Info, *Warn, *Error, *Fatal:
+		return gerrs.Unwrap(err).Error()
+	}
+	return err.Error()
+}
+
+// prefixError prefixes the message of err with prefix, keeping its level.
+func prefixError(err error, prefix string) error {
+	switch e := err.(type) {
+	case *Info:
+		return InfoWrap(e.error, prefix)
+	case *Warn:
+		return WarnWrap(e.error, prefix)
+	case *Error:
+		return ErrorWrap(e.error, prefix)
+	case *Fatal:
+		return FatalWrap(e.error, prefix)
+	}
+	return ErrorWrap(err, prefix)
+}
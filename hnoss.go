@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"net/netip"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/nightlyone/lockfile"
@@ -19,10 +23,31 @@ type (
 		ranAdapter       TimeAdapter
 		ipServiceAdapter IPServiceAdapter
 		ipCacheAdapter   IPAdapter
-		chatAdapter      ChatAdapter
-		nowAdapter       NowAdapter
-		ran              time.Time
-		ip               netip.Addr
+		historyAdapter   HistoryAdapter
+		// subscriptionAdapter is nil if subscriptions aren't supported.
+		subscriptionAdapter SubscriptionAdapter
+		// digestAdapter persists when the last digest was sent, nil if it isn't persisted.
+		digestAdapter TimeAdapter
+		chatAdapter   ChatAdapter
+		nowAdapter    NowAdapter
+		ran           time.Time
+		nextRun       time.Time
+		ip            netip.Addr
+		ipSource      string
+		changed       time.Time
+		failing       bool
+		// runErr is the error that failed the last run, nil if it succeeded.
+		runErr  error
+		started time.Time
+		// commands maps names to commands, commandNames is in the order registered.
+		commands     map[string]*Command
+		commandNames []string
+		// subscriptions are loaded from subscriptionAdapter when first needed, sorted by channel ID.
+		subscriptions       []*Subscription
+		subscriptionsLoaded bool
+		// digested is when the last digest was sent, loaded from digestAdapter when first needed.
+		digested       time.Time
+		digestedLoaded bool
 	}
 	// TimeAdapter should persist a time.Time
 	TimeAdapter interface {
@@ -38,10 +63,23 @@ type (
 	IPServiceAdapter interface {
 		Get() (netip.Addr, error)
 	}
+	// HistoryAdapter should persist a log of IP address changes.
+	HistoryAdapter interface {
+		Append(*Change) error
+		// List returns the n most recent changes, newest first.
+		List(n int) ([]*Change, error)
+	}
+	// Change records an observed change of IP address.
+	Change struct {
+		Time     time.Time
+		Old, New netip.Addr
+		// Source is the name of the adapter the new address was obtained from.
+		Source string
+	}
 	// ChatAdapter should provide an interface to a chat service.
 	ChatAdapter interface {
-		// Chan returns a channel on which is sent a chat channelID whenever the bot should reply.
-		Chan() <-chan string
+		// Chan returns a channel on which is sent a ChatEvent whenever the bot should reply.
+		Chan() <-chan *ChatEvent
 		// Listen opens a chat session, if a session is already open Listen should not return an error.
 		Listen() error
 		Close() error
@@ -52,28 +90,144 @@ type (
 	NowAdapter interface {
 		Now() time.Time
 	}
+	// Announcer may be implemented by a ChatAdapter to receive the details of announcements, rather than just
+	// the message through Post.
+	Announcer interface {
+		Announce(*Announcement) error
+	}
+	// ChannelAnnouncer may be implemented by a ChatAdapter to receive announcements to subscribed channels, rather than
+	// just the message through Post, see Outbox.
+	ChannelAnnouncer interface {
+		AnnounceChannel(*Announcement) error
+	}
+	// Announcement is a message the bot posts of its own accord, to the default channel, or a subscribed one.
+	Announcement struct {
+		Kind      AnnouncementKind
+		Message   string
+		IP, OldIP netip.Addr
+		Time      time.Time
+		Hostname  string
+		// ChanID is the subscribed channel the announcement is posted to, as for Post, empty for the default channel.
+		ChanID string
+		// render renders Message again after the Announcement is changed, as when an Outbox collapses changes, nil
+		// if it can't be.
+		render func(*Announcement) string
+	}
+	// StatusUpdater may be implemented by a ChatAdapter to be told the bot's status after every run.
+	// CommandSetter may be implemented by a ChatAdapter that offers commands natively, e.g. as Discord slash commands.
+	// SetCommands is called with every registered command before Listen.
+	CommandSetter interface {
+		SetCommands([]*Command)
+	}
+	StatusUpdater interface {
+		UpdateStatus(*Status) error
+	}
+	// Status is the state of the bot after a run.
+	Status struct {
+		IP netip.Addr
+		// Changed is when the IP address last changed, zero if never recorded.
+		Changed      time.Time
+		Ran, NextRun time.Time
+		// Err is the error that failed the run, nil if healthy.
+		Err error
+		// QueueDepth is the number of announcements waiting to be sent, see Queue.
+		QueueDepth int
+	}
+	// Queue may be implemented by a ChatAdapter that queues announcements, see Outbox.
+	Queue interface {
+		QueueDepth() int
+	}
+	// AnnouncementKind is the kind of event an Announcement reports, empty for a plain Post.
+	AnnouncementKind string
+	// ChatEvent is a request made of the bot in a chat channel.
+	ChatEvent struct {
+		ChanID     string
+		GuildID    string
+		AuthorID   string
+		AuthorName string
+		// RoleIDs are the author's roles in the guild, if the chat service has roles.
+		RoleIDs []string
+		// Text is the raw message text.
+		Text string
+		// Command and Args are parsed from Text, with any bot mention removed.
+		Command string
+		Args    []string
+		// Reply posts msg in response to the event, if nil msg is posted to ChanID instead.
+		Reply func(msg string) error
+		// AnnounceReply, if not nil, is used instead of Reply to respond with the details of the IP address.
+		AnnounceReply func(*Announcement) error
+		// ReplyDirect posts msg privately to the author, nil if the chat service can't, see Config.AccessDMOnly.
+		ReplyDirect func(msg string) error
+		// DirectNotice, if not nil, is called with a notice after replying by ReplyDirect, where the chat service is
+		// waiting for a response to the event.
+		DirectNotice func(msg string) error
+		// Dismiss, if not nil, withdraws a response the chat service is waiting for, when the event is ignored.
+		Dismiss func() error
+	}
+)
+
+const (
+	// ChangeAnnouncement reports a change of IP address.
+	ChangeAnnouncement AnnouncementKind = "change"
+	// ErrorAnnouncement reports a failure to get the IP address, see Config.AnnounceErrors.
+	ErrorAnnouncement AnnouncementKind = "error"
+	// ReplyAnnouncement answers a request for the IP address, see ChatEvent.AnnounceReply.
+	ReplyAnnouncement AnnouncementKind = "reply"
+)
+
+const (
+	ipCommand            = "ip"
+	refreshCommand       = "refresh"
+	statusCommand        = "status"
+	historyCommand       = "history"
+	defaultHistoryLength = 10
+	// maxHistoryLength limits the changes a history request may ask for.
+	maxHistoryLength = 50
 )
 
 var maxTime = time.Unix(1<<63-62135596801, 999999999)
 var zeroTime = time.Time{}
 
 func New(conf *Config, logger *Logger, ranAdapter TimeAdapter, ipServiceAdapter IPServiceAdapter,
-	ipCacheAdapter IPAdapter, chatAdapter ChatAdapter, nowAdapter NowAdapter) *Hnoss {
+	ipCacheAdapter IPAdapter, historyAdapter HistoryAdapter, subscriptionAdapter SubscriptionAdapter,
+	digestAdapter TimeAdapter, chatAdapter ChatAdapter, nowAdapter NowAdapter) *Hnoss {
 	h := &Hnoss{
-		config:           conf,
-		logger:           logger,
-		ranAdapter:       ranAdapter,
-		ipServiceAdapter: ipServiceAdapter,
-		ipCacheAdapter:   ipCacheAdapter,
-		chatAdapter:      chatAdapter,
-		nowAdapter:       nowAdapter,
+		config:              conf,
+		logger:              logger,
+		ranAdapter:          ranAdapter,
+		ipServiceAdapter:    ipServiceAdapter,
+		ipCacheAdapter:      ipCacheAdapter,
+		historyAdapter:      historyAdapter,
+		subscriptionAdapter: subscriptionAdapter,
+		digestAdapter:       digestAdapter,
+		chatAdapter:         chatAdapter,
+		nowAdapter:          nowAdapter,
+		commands:            map[string]*Command{},
+	}
+	for _, c := range builtinCommands {
+		_ = h.RegisterCommand(c)
+	}
+	if subscriptionAdapter != nil {
+		for _, c := range subscriptionCommands {
+			_ = h.RegisterCommand(c)
+		}
 	}
 	return h
 }
 
+// ParseCommand parses text, with any bot mention already removed, into a command and its arguments.
+func ParseCommand(text string) (command string, args []string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return strings.ToLower(fields[0]), fields[1:]
+}
+
 // Start starts the scheduler.
 func (h *Hnoss) Start(ctx context.Context) {
 	h.logger.Log(NewInfo("scheduler started"))
+	h.started = h.nowAdapter.Now()
 
 	var now, next time.Time
 	var runNow, wasAdvanced bool
@@ -84,29 +238,38 @@ func (h *Hnoss) Start(ctx context.Context) {
 	if _, err := h.getIP(true); err != nil {
 		h.logger.Log(err)
 	}
+	if setter, ok := h.chatAdapter.(CommandSetter); ok {
+		commands := make([]*Command, len(h.commandNames))
+		for i, name := range h.commandNames {
+			commands[i] = h.commands[name]
+		}
+		setter.SetCommands(commands)
+	}
 	if err := h.chatAdapter.Listen(); err != nil {
 		h.logger.Log(err)
 	}
+	h.announceEvent(h.started, StartupAnnouncement)
 
 	for {
 		now = h.nowAdapter.Now()
 		next, runNow, wasAdvanced = h.next(now, h.config.Offset, h.config.Interval)
+		h.nextRun = next
 
 		if runNow {
 			h.logger.Log(NewInfo("scheduled run missed, running now"))
-			h.run(now, false, "")
+			h.run(now, false, nil)
 		}
 		stopTimer(timer)
 		timer.Reset(time.Until(next))
 
 		select {
 		case <-timer.C:
-			h.run(next, wasAdvanced, "")
-		case chanID := <-call:
-			now = time.Now().UTC()
-			h.run(now, wasAdvanced, chanID)
+			h.run(next, wasAdvanced, nil)
+		case ev := <-call:
+			h.command(ev)
 		case <-done:
 			h.logger.Log(NewInfo("exiting scheduler"))
+			h.announceEvent(h.nowAdapter.Now(), ShutdownAnnouncement)
 			if err := h.chatAdapter.Close(); err != nil {
 				h.logger.Log(err)
 			}
@@ -128,14 +291,19 @@ func stopTimer(timer *time.Timer) {
 }
 
 // Get the ip address and post it, if necessary.
-func (h *Hnoss) run(t time.Time, cached bool, chanID string) {
+func (h *Hnoss) run(t time.Time, cached bool, ev *ChatEvent) {
 
-	// Record run after.
+	// Record run and update status after.
+	var runErr error
 	defer func() {
+		if ev == nil {
+			h.digest(t)
+		}
 		h.ran = t
 		if err := h.ranAdapter.Put(t); err != nil {
 			h.logger.Log(err)
 		}
+		h.updateStatus(runErr)
 	}()
 
 	// Call Listen again each run to make sure we're connected.
@@ -145,6 +313,7 @@ func (h *Hnoss) run(t time.Time, cached bool, chanID string) {
 			h.logger.Log(err)
 			var e *Error
 			if errors.As(err, &e) {
+				runErr = err
 				return
 			}
 		}
@@ -154,25 +323,226 @@ func (h *Hnoss) run(t time.Time, cached bool, chanID string) {
 	ip, err := h.getIP(cached)
 	if err != nil {
 		h.logger.Log(err)
+		h.announceError(t, ev, err)
+		runErr = err
+		return
+	}
+	if h.failing {
+		h.failing = false
+		h.announceEvent(t, RecoveredAnnouncement)
+	}
+
+	if ev != nil {
+		h.logger.Log(Infof("replying to %s", describeEvent(ev)))
+		a := &Announcement{Kind: ReplyAnnouncement, IP: ip, Time: t}
+		if cur != ip {
+			a.OldIP = cur
+		}
+		a.Message = h.message(ev, a, "")
+		if err = h.replyAnnouncement(ev, a); err != nil {
+			h.logger.Log(err)
+		}
+	}
+	if cur == ip {
+		h.logger.Log(NewInfo("ip address unchanged"))
+		return
+	}
+	// A change found by a request is announced too, the next scheduled run won't see it.
+	h.logger.Log(Infof("ip address changed from %s to %s", cur.String(), ip.String()))
+	h.changed = t
+	if err = h.historyAdapter.Append(&Change{Time: t, Old: cur, New: ip, Source: h.ipSource}); err != nil {
+		h.logger.Log(err)
+	}
+	a := &Announcement{Kind: ChangeAnnouncement, IP: ip, OldIP: cur, Time: t}
+	a.render = func(a *Announcement) string { return h.message(nil, a, "") }
+	a.Message = a.render(a)
+	if err = h.announce(a); err != nil {
+		h.logger.Log(err)
+	}
+	h.notify(a, "", nil)
+}
+
+// Respond to a chat command, if the author is allowed to make requests.
+func (h *Hnoss) command(ev *ChatEvent) {
+	if reason := h.config.checkAccess(ev); reason != "" {
+		h.logger.Log(Warnf("denied %q request from %s: %s", ev.Text, describeEvent(ev), reason))
+		if h.config.AccessRefusal != "" {
+			refusal, ok := h.lookup(ev, msgAccessRefusal)
+			if !ok {
+				refusal = h.config.AccessRefusal
+			}
+			if err := h.Reply(ev, refusal); err != nil {
+				h.logger.Log(err)
+			}
+		} else if ev.Dismiss != nil {
+			if err := ev.Dismiss(); err != nil {
+				h.logger.Log(err)
+			}
+		}
 		return
 	}
+	h.logger.Log(Infof("allowed %q request from %s", ev.Text, describeEvent(ev)))
+	if h.config.AccessDMOnly {
+		ev = directEvent(ev, h.tr(ev, msgRepliedDirect))
+	}
+	h.dispatch(ev)
+}
+
+// Reply with the current IP address and the last and next run times.
+func (h *Hnoss) status(ev *ChatEvent) error {
+	h.logger.Log(Infof("replying to status request from %s", describeEvent(ev)))
+	msg := h.tr(ev, msgStatus, addrString(h.ip), formatTime(h.ran), formatTime(h.nextRun))
+	if h.runErr != nil {
+		msg += "\n" + h.tr(ev, msgHealthFailing, errorMessage(h.runErr))
+	} else {
+		msg += "\n" + h.tr(ev, msgHealthOK)
+	}
+	if q, ok := h.chatAdapter.(Queue); ok {
+		msg += "\n" + h.tr(ev, msgQueued, q.QueueDepth())
+	}
+	return h.Reply(ev, msg)
+}
 
-	post := false
-	if chanID != "" {
-		h.logger.Log(Infof("replying to message on channel %s", chanID))
-		post = true
+// formatTime formats t as RFC3339, using "-" for the zero Time.
+func formatTime(t time.Time) string {
+	if t.Equal(zeroTime) {
+		return "-"
 	}
-	if cur != ip {
-		h.logger.Log(Infof("ip address changed from %s to %s", cur.String(), ip.String()))
-		post = true
+	return t.Format(time.RFC3339)
+}
+
+// Reply with the most recent IP address changes.
+func (h *Hnoss) history(ev *ChatEvent) error {
+	n := defaultHistoryLength
+	if len(ev.Args) > 0 {
+		var err error
+		n, err = strconv.Atoi(ev.Args[0])
+		if err != nil || n < 1 {
+			h.logger.Log(Warnf("invalid history length: %s", ev.Args[0]))
+			n = defaultHistoryLength
+		}
+		n = min(n, maxHistoryLength)
+	}
+	h.logger.Log(Infof("replying to history request from %s", describeEvent(ev)))
+	changes, err := h.historyAdapter.List(n)
+	if err != nil {
+		return err
 	}
-	if post {
-		if err = h.chatAdapter.Post(chanID, fmt.Sprintf(h.config.IPMessageFormat, ip.String())); err != nil {
+	if len(changes) == 0 {
+		return h.Reply(ev, h.tr(ev, msgHistoryEmpty))
+	}
+	return h.Reply(ev, h.tr(ev, msgHistory)+formatHistory(changes))
+}
+
+func (h *Hnoss) announce(a *Announcement) error {
+	a.Hostname, _ = os.Hostname()
+	if announcer, ok := h.chatAdapter.(Announcer); ok {
+		return announcer.Announce(a)
+	}
+	return h.chatAdapter.Post("", a.Message)
+}
+
+// announceChannel posts a to the subscribed channel a.ChanID, through the chat adapter if it takes announcements.
+func (h *Hnoss) announceChannel(a *Announcement) error {
+	if announcer, ok := h.chatAdapter.(ChannelAnnouncer); ok {
+		return announcer.AnnounceChannel(a)
+	}
+	return h.chatAdapter.Post(a.ChanID, a.Message)
+}
+
+// announceError announces err from a scheduled run if configured to, and notifies channels subscribed to errors,
+// only the first of consecutive failures is announced.
+func (h *Hnoss) announceError(t time.Time, ev *ChatEvent, err error) {
+	if ev != nil || h.failing {
+		return
+	}
+	h.failing = true
+	errMsg := errorMessage(err)
+	a := &Announcement{Kind: ErrorAnnouncement, IP: h.ip, Time: t}
+	a.Message = h.message(nil, a, errMsg)
+	if h.config.AnnounceErrors {
+		if err = h.announce(a); err != nil {
+			h.logger.Log(err)
+		}
+	}
+	h.notify(a, errMsg, nil)
+}
+
+// announceEvent announces an event of kind, only if it has a message template, and notifies channels subscribed to
+// it.
+func (h *Hnoss) announceEvent(t time.Time, kind AnnouncementKind) {
+	a := &Announcement{Kind: kind, IP: h.ip, Time: t}
+	if a.Message = h.message(nil, a, ""); a.Message != "" {
+		if err := h.announce(a); err != nil {
 			h.logger.Log(err)
 		}
+	}
+	h.notify(a, "", nil)
+}
+
+// updateStatus tells the chat adapter the bot's status, if it wants to know.
+func (h *Hnoss) updateStatus(runErr error) {
+	h.runErr = runErr
+	updater, ok := h.chatAdapter.(StatusUpdater)
+	if !ok {
 		return
 	}
-	h.logger.Log(NewInfo("ip address unchanged"))
+	if h.changed.Equal(zeroTime) {
+		changes, err := h.historyAdapter.List(1)
+		if err != nil {
+			h.logger.Log(err)
+		} else if len(changes) > 0 {
+			h.changed = changes[0].Time
+		}
+	}
+	s := &Status{IP: h.ip, Changed: h.changed, Ran: h.ran, NextRun: h.nextRun, Err: runErr}
+	if q, ok := h.chatAdapter.(Queue); ok {
+		s.QueueDepth = q.QueueDepth()
+	}
+	err := updater.UpdateStatus(s)
+	if err != nil {
+		h.logger.Log(err)
+	}
+}
+
+// replyAnnouncement replies to ev with a, or just its message if ev can't take an Announcement.
+func (h *Hnoss) replyAnnouncement(ev *ChatEvent, a *Announcement) error {
+	if ev.AnnounceReply == nil {
+		return h.Reply(ev, a.Message)
+	}
+	a.Hostname, _ = os.Hostname()
+	return ev.AnnounceReply(a)
+}
+
+// Reply responds to ev with msg.
+func (h *Hnoss) Reply(ev *ChatEvent, msg string) error {
+	if ev.Reply != nil {
+		return ev.Reply(msg)
+	}
+	return h.chatAdapter.Post(ev.ChanID, msg)
+}
+
+// describeEvent returns a description of who made ev and where, for logging.
+func describeEvent(ev *ChatEvent) string {
+	s := "message"
+	if ev.AuthorID != "" {
+		s = fmt.Sprintf("%s (%s)", ev.AuthorName, ev.AuthorID)
+	}
+	s += " on channel " + ev.ChanID
+	if ev.GuildID != "" {
+		s += " in guild " + ev.GuildID
+	}
+	return s
+}
+
+// formatHistory formats changes a line each, each line starting with a newline.
+func formatHistory(changes []*Change) string {
+	var b strings.Builder
+	for _, c := range changes {
+		fmt.Fprintf(&b, "\n%s %s -> %s (%s)", c.Time.Format(time.RFC3339), addrString(c.Old),
+			addrString(c.New), c.Source)
+	}
+	return b.String()
 }
 
 // Get the next run time.
@@ -229,6 +599,7 @@ func (h *Hnoss) getIP(cached bool) (netip.Addr, error) {
 			return h.ip, err
 		}
 		h.ip = ip
+		h.ipSource = adapterName(h.ipServiceAdapter)
 		if err = h.ipCacheAdapter.Put(ip); err != nil {
 			h.logger.Log(err)
 		}
@@ -238,10 +609,20 @@ func (h *Hnoss) getIP(cached bool) (netip.Addr, error) {
 		if err != nil {
 			return netip.Addr{}, err
 		}
+		h.ipSource = adapterName(h.ipCacheAdapter)
 	}
 	return h.ip, nil
 }
 
+// adapterName returns the type name of adapter a, without package or pointer.
+func adapterName(a any) string {
+	t := reflect.TypeOf(a)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
 func Lock(pidFile string) (func() error, error) {
 	p, err := filepath.Abs(pidFile)
 	if err != nil {
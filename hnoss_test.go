@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/netip"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -23,10 +24,18 @@ type (
 		err       error
 		called    bool
 	}
+	mockHistoryAdaptor struct {
+		changes []*Change
+	}
 	mockChatAdaptor struct {
-		c                   chan string
+		c                   chan *ChatEvent
 		postChanID, postMsg string
-		err                 error
+		// posts records every Post, as "<chanID>: <msg>".
+		posts []string
+		err   error
+	}
+	mockNowAdaptor struct {
+		now time.Time
 	}
 )
 
@@ -51,7 +60,20 @@ func (m *mockIPAdaptor) Put(ip netip.Addr) error {
 	return nil
 }
 
-func (m *mockChatAdaptor) Chan() <-chan string {
+func (m *mockHistoryAdaptor) Append(c *Change) error {
+	m.changes = append(m.changes, c)
+	return nil
+}
+
+func (m *mockHistoryAdaptor) List(n int) ([]*Change, error) {
+	var changes []*Change
+	for i := len(m.changes) - 1; i >= 0 && len(changes) < n; i-- {
+		changes = append(changes, m.changes[i])
+	}
+	return changes, nil
+}
+
+func (m *mockChatAdaptor) Chan() <-chan *ChatEvent {
 	return m.c
 }
 
@@ -66,9 +88,14 @@ func (m *mockChatAdaptor) Close() error {
 func (m *mockChatAdaptor) Post(chanId, msg string) error {
 	m.postChanID = chanId
 	m.postMsg = msg
+	m.posts = append(m.posts, chanId+": "+msg)
 	return nil
 }
 
+func (m *mockNowAdaptor) Now() time.Time {
+	return m.now
+}
+
 var nextRunTimeTestCases = []struct {
 	description                      string
 	nowS, offsetS, intervalS, xNextS string
@@ -97,7 +124,7 @@ func TestNext(t *testing.T) {
 	r, err := time.Parse(time.RFC3339, "2023-11-28T13:05:00Z")
 	require.NoError(t, err)
 	ran := &mockTimeAdaptor{time: r}
-	h := New(nil, logger, ran, nil, nil, nil, nil)
+	h := New(nil, logger, ran, nil, nil, nil, nil, nil, nil, nil)
 
 	for _, tc := range nextRunTimeTestCases {
 		t.Run(tc.description, func(t *testing.T) {
@@ -137,10 +164,11 @@ func TestScheduler(t *testing.T) {
 	}
 	ipService := &mockIPAdaptor{ip: newIP(t, "0.0.0.0")}
 	ipCache := &mockIPAdaptor{ip: newIP(t, "1.2.3.4")}
-	chat := &mockChatAdaptor{c: make(chan string)}
+	history := &mockHistoryAdaptor{}
+	chat := &mockChatAdaptor{c: make(chan *ChatEvent)}
 	now := NewRealNowAdapter()
 
-	h := New(conf, logger, ran, ipService, ipCache, chat, now)
+	h := New(conf, logger, ran, ipService, ipCache, history, nil, nil, chat, now)
 	ctx, cancel := context.WithCancel(context.Background())
 	wg.Add(1)
 	go func() {
@@ -153,8 +181,13 @@ func TestScheduler(t *testing.T) {
 
 	assert.Equal(t, "", chat.postChanID)
 	assert.Equal(t, "0.0.0.0", chat.postMsg)
+	require.Len(t, history.changes, 1)
+	assert.Equal(t, ipCache.ip, history.changes[0].Old)
+	assert.Equal(t, ipService.ip, history.changes[0].New)
+	assert.Equal(t, "mockIPAdaptor", history.changes[0].Source)
 
-	chat.c <- "1234"
+	// Only a refresh is recorded as a run.
+	chat.c <- &ChatEvent{ChanID: "1234", AuthorID: "5678", AuthorName: "user#0001", Command: refreshCommand}
 	chat.err = NewWarn("A warning")
 
 	wg.Wait()
@@ -184,7 +217,7 @@ func TestGetIP(t *testing.T) {
 
 	ipService := &mockIPAdaptor{err: e}
 	ipCache := &mockIPAdaptor{err: e}
-	h := New(nil, nil, nil, ipService, ipCache, nil, nil)
+	h := New(nil, nil, nil, ipService, ipCache, nil, nil, nil, nil, nil)
 
 	_, err := h.getIP(true)
 	assert.Error(t, err)
@@ -206,6 +239,174 @@ func TestGetIP(t *testing.T) {
 	assert.Equal(t, ipService.ip, ipCache.putIP)
 }
 
+func TestHistory(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	history := &mockHistoryAdaptor{}
+	chat := &mockChatAdaptor{}
+	h := New(nil, logger, nil, nil, nil, history, nil, nil, chat, nil)
+
+	h.history(&ChatEvent{ChanID: "1234", Command: "history"})
+	assert.Equal(t, "1234", chat.postChanID)
+	assert.Equal(t, "no ip address changes recorded", chat.postMsg)
+
+	for _, s := range []string{"1.2.3.4", "5.6.7.8", "9.10.11.12"} {
+		history.changes = append(history.changes, &Change{
+			Time:   newTime(t, "2023-11-28T14:00:00Z"),
+			New:    newIP(t, s),
+			Source: "mockIPAdaptor",
+		})
+	}
+	var reply string
+	h.history(&ChatEvent{ChanID: "1234", Command: "history", Args: []string{"2"}, Reply: func(msg string) error {
+		reply = msg
+		return nil
+	}})
+	assert.Equal(t, "ip address history:\n"+
+		"2023-11-28T14:00:00Z - -> 9.10.11.12 (mockIPAdaptor)\n"+
+		"2023-11-28T14:00:00Z - -> 5.6.7.8 (mockIPAdaptor)", reply)
+
+	// Requests for more than the maximum are limited to it.
+	for i := 0; i < maxHistoryLength; i++ {
+		history.changes = append(history.changes, history.changes[0])
+	}
+	h.history(&ChatEvent{ChanID: "1234", Command: "history", Args: []string{"1152921504606846976"},
+		Reply: func(msg string) error {
+			reply = msg
+			return nil
+		}})
+	assert.Len(t, strings.Split(reply, "\n"), 1+maxHistoryLength)
+}
+
+type mockAnnouncerChatAdaptor struct {
+	mockChatAdaptor
+	announcement *Announcement
+}
+
+func (m *mockAnnouncerChatAdaptor) Announce(a *Announcement) error {
+	m.announcement = a
+	return nil
+}
+
+func TestAnnounce(t *testing.T) {
+	chat := &mockChatAdaptor{}
+	h := New(nil, nil, nil, nil, nil, nil, nil, nil, chat, nil)
+	require.NoError(t, h.announce(&Announcement{Message: "1.2.3.4"}))
+	assert.Equal(t, "", chat.postChanID)
+	assert.Equal(t, "1.2.3.4", chat.postMsg)
+
+	announcer := &mockAnnouncerChatAdaptor{}
+	h = New(nil, nil, nil, nil, nil, nil, nil, nil, announcer, nil)
+	a := &Announcement{Message: "1.2.3.4", IP: newIP(t, "1.2.3.4")}
+	require.NoError(t, h.announce(a))
+	assert.Equal(t, a, announcer.announcement)
+	assert.Equal(t, "", announcer.postMsg)
+}
+
+func TestAnnounceError(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	ipService := &mockIPAdaptor{err: NewError("An error")}
+	announcer := &mockAnnouncerChatAdaptor{}
+	conf := &Config{IPMessageFormat: "%s", AnnounceErrors: true}
+	h := New(conf, logger, &mockTimeAdaptor{}, ipService, &mockIPAdaptor{}, &mockHistoryAdaptor{}, nil, nil,
+		announcer, nil)
+	now := newTime(t, "2023-11-28T14:00:00Z")
+
+	h.run(now, false, nil)
+	require.NotNil(t, announcer.announcement)
+	assert.Equal(t, ErrorAnnouncement, announcer.announcement.Kind)
+	assert.Equal(t, "failed to get ip address: An error", announcer.announcement.Message)
+
+	// Only the first of consecutive failures is announced.
+	announcer.announcement = nil
+	h.run(now, false, nil)
+	assert.Nil(t, announcer.announcement)
+
+	ipService.err = nil
+	ipService.ip = newIP(t, "1.2.3.4")
+	h.run(now, false, nil)
+	require.NotNil(t, announcer.announcement)
+	assert.Equal(t, ChangeAnnouncement, announcer.announcement.Kind)
+
+	ipService.err = NewError("An error")
+	h.run(now, false, nil)
+	assert.Equal(t, ErrorAnnouncement, announcer.announcement.Kind)
+}
+
+type mockStatusChatAdaptor struct {
+	mockChatAdaptor
+	status *Status
+}
+
+func (m *mockStatusChatAdaptor) UpdateStatus(s *Status) error {
+	m.status = s
+	return nil
+}
+
+func TestUpdateStatus(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	ipService := &mockIPAdaptor{ip: newIP(t, "1.2.3.4")}
+	history := &mockHistoryAdaptor{changes: []*Change{{Time: newTime(t, "2023-11-27T14:00:00Z")}}}
+	chat := &mockStatusChatAdaptor{}
+	conf := &Config{IPMessageFormat: "%s"}
+	h := New(conf, logger, &mockTimeAdaptor{}, ipService, &mockIPAdaptor{}, history, nil, nil, chat, nil)
+	h.ip = ipService.ip
+	now := newTime(t, "2023-11-28T14:00:00Z")
+
+	// Changed is loaded from history until the address changes.
+	h.run(now, false, nil)
+	require.NotNil(t, chat.status)
+	assert.Equal(t, &Status{IP: ipService.ip, Changed: newTime(t, "2023-11-27T14:00:00Z"), Ran: now}, chat.status)
+
+	ipService.ip = newIP(t, "5.6.7.8")
+	h.run(now, false, nil)
+	assert.Equal(t, now, chat.status.Changed)
+
+	ipService.err = NewError("An error")
+	h.run(now, false, nil)
+	assert.Equal(t, ipService.err, chat.status.Err)
+}
+
+func TestReplyAnnouncement(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	ipService := &mockIPAdaptor{ip: newIP(t, "1.2.3.4")}
+	chat := &mockChatAdaptor{}
+	conf := &Config{IPMessageFormat: "%s:2456"}
+	h := New(conf, logger, &mockTimeAdaptor{}, ipService, &mockIPAdaptor{}, &mockHistoryAdaptor{}, nil, nil, chat, nil)
+	h.ip = newIP(t, "5.6.7.8")
+	now := newTime(t, "2023-11-28T14:00:00Z")
+
+	var a *Announcement
+	h.run(now, false, &ChatEvent{ChanID: "1234", AnnounceReply: func(reply *Announcement) error {
+		a = reply
+		return nil
+	}})
+	require.NotNil(t, a)
+	assert.Equal(t, ReplyAnnouncement, a.Kind)
+	assert.Equal(t, "1.2.3.4:2456", a.Message)
+	assert.Equal(t, newIP(t, "1.2.3.4"), a.IP)
+	assert.Equal(t, newIP(t, "5.6.7.8"), a.OldIP)
+	// The change is announced to the default channel as well.
+	assert.Equal(t, []string{": 1.2.3.4:2456"}, chat.posts)
+
+	// Without AnnounceReply the message is posted.
+	h.run(now, false, &ChatEvent{ChanID: "1234"})
+	assert.Equal(t, "1234", chat.postChanID)
+	assert.Equal(t, "1.2.3.4:2456", chat.postMsg)
+}
+
+func TestParseCommand(t *testing.T) {
+	cmd, args := ParseCommand(" History  5 ")
+	assert.Equal(t, "history", cmd)
+	assert.Equal(t, []string{"5"}, args)
+	cmd, args = ParseCommand("")
+	assert.Equal(t, "", cmd)
+	assert.Empty(t, args)
+}
+
 func newTime(t *testing.T, s string) time.Time {
 	n, err := time.Parse(time.RFC3339Nano, s)
 	require.NoError(t, err)
@@ -0,0 +1,354 @@
+package hnoss
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type (
+	// IRCChatAdapter replies when its nick is highlighted in, or privately messaged on, an IRC network.
+	IRCChatAdapter struct {
+		addr           string
+		nick           string
+		defaultChannel string
+		opts           IRCOptions
+
+		c          chan *ChatEvent
+		conn       *ircConn
+		stop, done chan struct{}
+		mu         sync.Mutex
+		sendMu     sync.Mutex
+		lastSend   time.Time
+	}
+	// IRCOptions configures optional IRCChatAdapter behaviour.
+	IRCOptions struct {
+		TLS bool
+		// SASLUser and SASLPassword authenticate with SASL PLAIN during registration, if SASLUser is set.
+		SASLUser     string
+		SASLPassword string
+		// PrivateMessages answers commands sent directly to the bot, not only highlights in channels.
+		PrivateMessages bool
+	}
+	ircConn struct {
+		net.Conn
+		nick   string
+		ready  chan error
+		closed chan struct{}
+		mu     sync.Mutex
+	}
+	ircMessage struct {
+		prefix, command string
+		params          []string
+	}
+)
+
+var (
+	ircRegisterTimeout = 30 * time.Second
+	ircMinBackoff      = time.Second
+	ircMaxBackoff      = 5 * time.Minute
+	// ircFloodDelay is the minimum time between PRIVMSGs, to stay clear of server flood protection.
+	ircFloodDelay = time.Second
+)
+
+// ircMaxText is the maximum number of bytes of text in a PRIVMSG, leaving room for the prefix the server adds
+// within the 512 byte line limit.
+const ircMaxText = 400
+
+// NewIRCChatAdapter takes addr as host:port.
+func NewIRCChatAdapter(addr, nick, defaultChannel string, opts IRCOptions) *IRCChatAdapter {
+	return &IRCChatAdapter{
+		addr:           addr,
+		nick:           nick,
+		defaultChannel: defaultChannel,
+		opts:           opts,
+		c:              make(chan *ChatEvent),
+	}
+}
+
+func (i *IRCChatAdapter) Chan() <-chan *ChatEvent {
+	return i.c
+}
+
+func (i *IRCChatAdapter) Listen() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.stop != nil {
+		return NewWarn("IRC already connected")
+	}
+	conn, err := i.connect()
+	if err != nil {
+		return err
+	}
+	i.conn = conn
+	i.stop = make(chan struct{})
+	i.done = make(chan struct{})
+	go i.maintain(conn, i.stop, i.done)
+	return NewInfo("connected to IRC")
+}
+
+// connect dials and registers, returning once registered with the connection being read.
+func (i *IRCChatAdapter) connect() (*ircConn, error) {
+	var nc net.Conn
+	var err error
+	if i.opts.TLS {
+		host, _, _ := net.SplitHostPort(i.addr)
+		nc, err = tls.Dial("tcp", i.addr, &tls.Config{ServerName: host})
+	} else {
+		nc, err = net.Dial("tcp", i.addr)
+	}
+	if err != nil {
+		return nil, ErrorWrapf(err, "failed to connect to IRC server: %s", i.addr)
+	}
+	c := &ircConn{Conn: nc, nick: i.nick, ready: make(chan error, 1), closed: make(chan struct{})}
+	go i.read(c)
+
+	if i.opts.SASLUser != "" {
+		c.send("CAP REQ :sasl")
+	}
+	c.send("NICK " + i.nick)
+	c.send("USER " + i.nick + " 0 * :hnoss")
+
+	select {
+	case err = <-c.ready:
+	case <-c.closed:
+		err = Errorf("IRC server closed connection during registration: %s", i.addr)
+	case <-time.After(ircRegisterTimeout):
+		err = Errorf("timed out registering with IRC server: %s", i.addr)
+	}
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reconnect with backoff whenever c is closed, until stop is closed.
+func (i *IRCChatAdapter) maintain(c *ircConn, stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-c.closed:
+		case <-stop:
+			c.send("QUIT :bye")
+			_ = c.Close()
+			return
+		}
+		backoff := ircMinBackoff
+		for {
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				return
+			}
+			var err error
+			if c, err = i.connect(); err == nil {
+				break
+			}
+			backoff *= 2
+			if backoff > ircMaxBackoff {
+				backoff = ircMaxBackoff
+			}
+		}
+		i.mu.Lock()
+		i.conn = c
+		i.mu.Unlock()
+	}
+}
+
+// Read and handle lines from c until it's closed.
+func (i *IRCChatAdapter) read(c *ircConn) {
+	defer close(c.closed)
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		m := parseIRCMessage(scanner.Text())
+		switch m.command {
+		case "PING":
+			c.send("PONG :" + m.param(0))
+		case "CAP":
+			if m.param(1) == "ACK" && strings.Contains(m.param(2), "sasl") {
+				c.send("AUTHENTICATE PLAIN")
+			} else if m.param(1) == "NAK" {
+				c.setReady(Errorf("IRC server does not support SASL"))
+			}
+		case "AUTHENTICATE":
+			if m.param(0) == "+" {
+				u, p := i.opts.SASLUser, i.opts.SASLPassword
+				c.send("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(u+"\x00"+u+"\x00"+p)))
+			}
+		case "903":
+			c.send("CAP END")
+		case "904", "905", "906":
+			c.setReady(Errorf("IRC SASL authentication failed: %s", m.param(len(m.params)-1)))
+		case "433":
+			// Nick in use, try another.
+			c.nick += "_"
+			c.send("NICK " + c.nick)
+		case "001":
+			c.nick = m.param(0)
+			if i.defaultChannel != "" {
+				c.send("JOIN " + i.defaultChannel)
+			}
+			c.setReady(nil)
+		case "PRIVMSG":
+			i.privmsg(c, m)
+		}
+	}
+	_ = c.Close()
+}
+
+func (i *IRCChatAdapter) privmsg(c *ircConn, m *ircMessage) {
+	sender := m.nick()
+	target, text := m.param(0), m.param(1)
+	// CTCP requests and ACTIONs aren't commands.
+	if target == "" || strings.HasPrefix(text, "\x01") {
+		return
+	}
+	private := !strings.ContainsAny(target[:1], "#&+!")
+	if private && !i.opts.PrivateMessages {
+		return
+	}
+	if sender == c.nick || (!private && !strings.Contains(strings.ToLower(text), strings.ToLower(c.nick))) {
+		return
+	}
+	chanID := target
+	if private {
+		chanID = sender
+	}
+	ev := &ChatEvent{
+		ChanID:     chanID,
+		AuthorID:   m.prefix,
+		AuthorName: sender,
+		Text:       text,
+		Reply: func(msg string) error {
+			if !private {
+				msg = sender + ": " + msg
+			}
+			return i.Post(chanID, msg)
+		},
+		ReplyDirect: func(msg string) error {
+			return i.Post(sender, msg)
+		},
+	}
+	var fields []string
+	for _, f := range strings.Fields(text) {
+		if !strings.EqualFold(strings.TrimRight(f, ":,"), c.nick) {
+			fields = append(fields, f)
+		}
+	}
+	ev.Command, ev.Args = ParseCommand(strings.Join(fields, " "))
+	i.c <- ev
+}
+
+func (i *IRCChatAdapter) Close() error {
+	i.mu.Lock()
+	stop, done := i.stop, i.done
+	i.stop = nil
+	i.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	// maintain needs the lock to swap connections, so wait without it.
+	close(stop)
+	<-done
+	i.mu.Lock()
+	i.conn = nil
+	i.mu.Unlock()
+	return nil
+}
+
+// Post msg to chanID, split into as many PRIVMSGs as necessary and paced to avoid flooding.
+func (i *IRCChatAdapter) Post(chanID, msg string) error {
+	if chanID == "" {
+		chanID = i.defaultChannel
+	}
+	i.mu.Lock()
+	c := i.conn
+	i.mu.Unlock()
+	if c == nil {
+		return NewError("failed to send IRC message: not connected")
+	}
+	i.sendMu.Lock()
+	defer i.sendMu.Unlock()
+	for _, line := range splitIRCText(msg, ircMaxText) {
+		time.Sleep(time.Until(i.lastSend.Add(ircFloodDelay)))
+		if err := c.send("PRIVMSG " + chanID + " :" + line); err != nil {
+			return ErrorWrap(err, "failed to send IRC message")
+		}
+		i.lastSend = time.Now()
+	}
+	return nil
+}
+
+// splitIRCText splits text into non-empty lines of at most max bytes, without splitting UTF-8 characters.
+func splitIRCText(text string, max int) []string {
+	var lines []string
+	for _, l := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		for len(l) > max {
+			n := max
+			for n > 0 && !utf8.RuneStart(l[n]) {
+				n--
+			}
+			lines = append(lines, l[:n])
+			l = l[n:]
+		}
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// setReady reports the result of registration, only the first result is kept.
+func (c *ircConn) setReady(err error) {
+	select {
+	case c.ready <- err:
+	default:
+	}
+}
+
+func (c *ircConn) send(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// parseIRCMessage parses an RFC 1459 message, ignoring any IRCv3 tags.
+func parseIRCMessage(line string) *ircMessage {
+	m := &ircMessage{}
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		m.prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	line, trailing, hasTrailing := strings.Cut(line, " :")
+	fields := strings.Fields(line)
+	if len(fields) > 0 {
+		m.command = strings.ToUpper(fields[0])
+		m.params = fields[1:]
+	}
+	if hasTrailing {
+		m.params = append(m.params, trailing)
+	}
+	return m
+}
+
+func (m *ircMessage) param(n int) string {
+	if n < 0 || n >= len(m.params) {
+		return ""
+	}
+	return m.params[n]
+}
+
+// nick returns the nick part of the message prefix.
+func (m *ircMessage) nick() string {
+	nick, _, _ := strings.Cut(m.prefix, "!")
+	return nick
+}
@@ -0,0 +1,146 @@
+package hnoss
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIRCServer accepts connections, completing registration and sending each line received on lines.
+type fakeIRCServer struct {
+	net.Listener
+	lines chan string
+	conns chan net.Conn
+}
+
+func newFakeIRCServer(t *testing.T) *fakeIRCServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeIRCServer{Listener: l, lines: make(chan string, 100), conns: make(chan net.Conn, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+			go s.handle(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	return s
+}
+
+func (s *fakeIRCServer) handle(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		s.lines <- line
+		var res string
+		switch {
+		case line == "CAP REQ :sasl":
+			res = ":irc.test CAP * ACK :sasl"
+		case line == "AUTHENTICATE PLAIN":
+			res = "AUTHENTICATE +"
+		case strings.HasPrefix(line, "AUTHENTICATE "):
+			res = ":irc.test 903 hnoss :SASL authentication successful"
+		case line == "NICK hnoss":
+			res = ":irc.test 433 * hnoss :Nickname is already in use"
+		case line == "CAP END":
+			res = ":irc.test 001 hnoss_ :Welcome\r\nPING :irc.test"
+		}
+		if res != "" {
+			_, _ = conn.Write([]byte(res + "\r\n"))
+		}
+	}
+}
+
+// expect reads lines until want, failing on timeout.
+func (s *fakeIRCServer) expect(t *testing.T, want string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-s.lines:
+			if line == want {
+				return
+			}
+		case <-timeout:
+			require.Fail(t, "timed out waiting for line", want)
+		}
+	}
+}
+
+func TestIRCChatAdapter(t *testing.T) {
+	defer func(d, b time.Duration) {
+		ircFloodDelay, ircMinBackoff = d, b
+	}(ircFloodDelay, ircMinBackoff)
+	ircFloodDelay = time.Millisecond
+	ircMinBackoff = time.Millisecond
+
+	s := newFakeIRCServer(t)
+	i := NewIRCChatAdapter(s.Addr().String(), "hnoss", "#valheim", IRCOptions{SASLUser: "user", SASLPassword: "pass",
+		PrivateMessages: true})
+
+	var info *Info
+	assert.ErrorAs(t, i.Listen(), &info)
+	s.expect(t, "NICK hnoss_")
+	s.expect(t, "AUTHENTICATE dXNlcgB1c2VyAHBhc3M=")
+	s.expect(t, "CAP END")
+	s.expect(t, "JOIN #valheim")
+	s.expect(t, "PONG :irc.test")
+	var warn *Warn
+	assert.ErrorAs(t, i.Listen(), &warn)
+	conn := <-s.conns
+
+	_, err := conn.Write([]byte(":someone!u@h PRIVMSG #valheim :not for the bot\r\n" +
+		":someone!u@h PRIVMSG #valheim :\x01ACTION waves at hnoss_\x01\r\n" +
+		":someone!u@h PRIVMSG #valheim :hnoss_: history 4\r\n"))
+	require.NoError(t, err)
+	ev := <-i.Chan()
+	assert.Equal(t, "#valheim", ev.ChanID)
+	assert.Equal(t, "someone", ev.AuthorName)
+	assert.Equal(t, "history", ev.Command)
+	assert.Equal(t, []string{"4"}, ev.Args)
+	require.NoError(t, ev.Reply("1.2.3.4"))
+	s.expect(t, "PRIVMSG #valheim :someone: 1.2.3.4")
+
+	_, err = conn.Write([]byte(":someone!u@h PRIVMSG hnoss_ :\x01VERSION\x01\r\n" +
+		":someone!u@h PRIVMSG hnoss_ :ip\r\n"))
+	require.NoError(t, err)
+	ev = <-i.Chan()
+	assert.Equal(t, "someone", ev.ChanID)
+	assert.Equal(t, "ip", ev.Command)
+
+	require.NoError(t, i.Post("", "a\nb"))
+	s.expect(t, "PRIVMSG #valheim :a")
+	s.expect(t, "PRIVMSG #valheim :b")
+
+	// Reconnect after the server drops the connection.
+	require.NoError(t, conn.Close())
+	<-s.conns
+	s.expect(t, "JOIN #valheim")
+
+	require.NoError(t, i.Close())
+	s.expect(t, "QUIT :bye")
+}
+
+func TestSplitIRCText(t *testing.T) {
+	assert.Equal(t, []string{"ab", "c", "de"}, splitIRCText("abc\r\n\nde", 2))
+	assert.Equal(t, []string{"a", "é", "b"}, splitIRCText("aéb", 2))
+}
+
+func TestParseIRCMessage(t *testing.T) {
+	m := parseIRCMessage("@time=x :nick!user@host PRIVMSG #chan :hello there")
+	assert.Equal(t, &ircMessage{prefix: "nick!user@host", command: "PRIVMSG",
+		params: []string{"#chan", "hello there"}}, m)
+	assert.Equal(t, "nick", m.nick())
+	m = parseIRCMessage("PING :irc.test")
+	assert.Equal(t, "irc.test", m.param(0))
+}
@@ -41,9 +41,15 @@ func main() {
 	ran := hnoss.NewTextFileTimeAdapter(conf.RanFile)
 	ipService := hnoss.NewPlainTextIPServiceAdapter(conf.IPServiceURL)
 	ipCache := hnoss.NewTextFileIPAdapter(conf.IPCacheFile)
-	chat := hnoss.NewDiscordChatAdapter(conf.DiscordBotToken, conf.DiscordDefaultChannelName)
+	history := hnoss.NewTextFileHistoryAdapter(conf.HistoryFile)
+	subscriptions := hnoss.NewJSONFileSubscriptionAdapter(conf.SubscriptionFile)
+	digest := hnoss.NewTextFileTimeAdapter(conf.DigestFile)
+	chat, err := hnoss.NewChatAdapter(conf, logger)
+	if err != nil {
+		panic(err)
+	}
 	now := hnoss.NewRealNowAdapter()
 
-	h := hnoss.New(conf, logger, ran, ipService, ipCache, chat, now)
+	h := hnoss.New(conf, logger, ran, ipService, ipCache, history, subscriptions, digest, chat, now)
 	h.Start(ctx)
 }
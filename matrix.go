@@ -0,0 +1,285 @@
+package hnoss
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// MatrixChatAdapter receives mentions with Matrix client-server API /sync long polling and posts with
+	// m.room.message events.
+	MatrixChatAdapter struct {
+		homeserver       string
+		accessToken      string
+		defaultRoomAlias string
+		syncFile         string
+
+		userID        string
+		defaultRoomID string
+		since         string
+		txn           atomic.Int64
+		c             chan *ChatEvent
+		cancel        context.CancelFunc
+		done          chan struct{}
+		mu            sync.Mutex
+	}
+	matrixSync struct {
+		NextBatch string `json:"next_batch"`
+		Rooms     struct {
+			Join map[string]struct {
+				Timeline struct {
+					Events []*matrixEvent `json:"events"`
+				} `json:"timeline"`
+			} `json:"join"`
+		} `json:"rooms"`
+	}
+	matrixEvent struct {
+		Type    string `json:"type"`
+		EventID string `json:"event_id"`
+		Sender  string `json:"sender"`
+		Content struct {
+			MsgType  string `json:"msgtype"`
+			Body     string `json:"body"`
+			Mentions *struct {
+				UserIDs []string `json:"user_ids"`
+			} `json:"m.mentions"`
+		} `json:"content"`
+	}
+)
+
+const (
+	matrixClientPath   = "/_matrix/client/v3/"
+	matrixSyncTimeout  = 30 * time.Second
+	matrixRetryBackoff = 5 * time.Second
+)
+
+// NewMatrixChatAdapter takes the homeserver base URL, e.g. https://matrix.org, and persists the sync token in
+// syncFile so that mentions are not replayed after a restart.
+func NewMatrixChatAdapter(homeserver, accessToken, defaultRoomAlias, syncFile string) *MatrixChatAdapter {
+	return &MatrixChatAdapter{
+		homeserver:       strings.TrimSuffix(homeserver, "/"),
+		accessToken:      accessToken,
+		defaultRoomAlias: defaultRoomAlias,
+		syncFile:         syncFile,
+		c:                make(chan *ChatEvent),
+	}
+}
+
+func (m *MatrixChatAdapter) Chan() <-chan *ChatEvent {
+	return m.c
+}
+
+func (m *MatrixChatAdapter) Listen() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		return NewWarn("Matrix already syncing")
+	}
+
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := m.call(context.Background(), http.MethodGet, "account/whoami", nil, &whoami); err != nil {
+		return err
+	}
+	m.userID = whoami.UserID
+	var room struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := m.call(context.Background(), http.MethodGet, "directory/room/"+url.PathEscape(m.defaultRoomAlias),
+		nil, &room); err != nil {
+		return err
+	}
+	m.defaultRoomID = room.RoomID
+
+	if err := m.loadSince(); err != nil {
+		return err
+	}
+	if m.since == "" {
+		// Skip everything that happened before first run.
+		res, err := m.sync(context.Background(), 0)
+		if err != nil {
+			return err
+		}
+		if err = m.saveSince(res.NextBatch); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.syncLoop(ctx, m.done)
+	return NewInfo("connected to Matrix")
+}
+
+// Long poll /sync until ctx is cancelled, sending a ChatEvent for each mention.
+func (m *MatrixChatAdapter) syncLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	for {
+		res, err := m.sync(ctx, matrixSyncTimeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			select {
+			case <-time.After(matrixRetryBackoff):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		for roomID, room := range res.Rooms.Join {
+			for _, e := range room.Timeline.Events {
+				if m.mentioned(e) {
+					select {
+					case m.c <- m.newChatEvent(roomID, e):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+		// Persistence failure only risks replaying mentions, carry on.
+		_ = m.saveSince(res.NextBatch)
+	}
+}
+
+func (m *MatrixChatAdapter) sync(ctx context.Context, timeout time.Duration) (*matrixSync, error) {
+	q := url.Values{"timeout": {fmt.Sprint(timeout.Milliseconds())}}
+	if m.since != "" {
+		q.Set("since", m.since)
+	} else {
+		q.Set("filter", `{"room":{"timeline":{"limit":1}}}`)
+	}
+	res := &matrixSync{}
+	if err := m.call(ctx, http.MethodGet, "sync?"+q.Encode(), nil, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (m *MatrixChatAdapter) mentioned(e *matrixEvent) bool {
+	if e.Type != "m.room.message" || e.Sender == m.userID {
+		return false
+	}
+	if e.Content.Mentions != nil {
+		for _, id := range e.Content.Mentions.UserIDs {
+			if id == m.userID {
+				return true
+			}
+		}
+	}
+	return strings.Contains(e.Content.Body, m.userID)
+}
+
+func (m *MatrixChatAdapter) newChatEvent(roomID string, e *matrixEvent) *ChatEvent {
+	ev := &ChatEvent{
+		ChanID:     roomID,
+		AuthorID:   e.Sender,
+		AuthorName: e.Sender,
+		Text:       e.Content.Body,
+		Reply: func(msg string) error {
+			return m.post(roomID, msg, e.EventID)
+		},
+	}
+	text := strings.ReplaceAll(e.Content.Body, m.userID, "")
+	// Clients mention by display name, e.g. "hnoss: history", drop it.
+	if fields := strings.Fields(text); len(fields) > 0 && strings.HasSuffix(fields[0], ":") {
+		text = strings.Join(fields[1:], " ")
+	}
+	ev.Command, ev.Args = ParseCommand(text)
+	return ev
+}
+
+func (m *MatrixChatAdapter) loadSince() (err error) {
+	m.since, err = readStringFile(m.syncFile, "Matrix sync")
+	return
+}
+
+func (m *MatrixChatAdapter) saveSince(since string) error {
+	m.since = since
+	return writeStringFile(m.syncFile, "Matrix sync", since)
+}
+
+func (m *MatrixChatAdapter) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel == nil {
+		return nil
+	}
+	m.cancel()
+	<-m.done
+	m.cancel = nil
+	return nil
+}
+
+func (m *MatrixChatAdapter) Post(chanID, msg string) error {
+	if chanID == "" {
+		chanID = m.defaultRoomID
+	}
+	return m.post(chanID, msg, "")
+}
+
+// post msg to roomID, in reply to event replyTo if not empty.
+func (m *MatrixChatAdapter) post(roomID, msg, replyTo string) error {
+	content := map[string]any{"msgtype": "m.notice", "body": msg}
+	if replyTo != "" {
+		content["m.relates_to"] = map[string]any{"m.in_reply_to": map[string]string{"event_id": replyTo}}
+	}
+	txnID := fmt.Sprintf("hnoss%d.%d", time.Now().UnixNano(), m.txn.Add(1))
+	path := "rooms/" + url.PathEscape(roomID) + "/send/m.room.message/" + txnID
+	return m.call(context.Background(), http.MethodPut, path, content, nil)
+}
+
+// call the client-server API endpoint path, sending body and decoding the response into res, if not nil.
+func (m *MatrixChatAdapter) call(ctx context.Context, method, path string, body, res any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return ErrorWrap(err, "failed to encode Matrix request")
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, m.homeserver+matrixClientPath+path, r)
+	if err != nil {
+		return ErrorWrap(err, "failed to create Matrix request")
+	}
+	req.Header.Set("Authorization", "Bearer "+m.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return ErrorWrapf(err, "failed to call Matrix %s", strings.SplitN(path, "?", 2)[0])
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			ErrCode      string `json:"errcode"`
+			Error        string `json:"error"`
+			RetryAfterMS int    `json:"retry_after_ms"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		err = Errorf("Matrix %s failed: %s %s: %s", strings.SplitN(path, "?", 2)[0], resp.Status, e.ErrCode, e.Error)
+		if e.RetryAfterMS > 0 {
+			return &retryAfterError{err: err, after: time.Duration(e.RetryAfterMS) * time.Millisecond}
+		}
+		return withRetryAfter(err, resp.Header)
+	}
+	if res == nil {
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return ErrorWrap(err, "failed to decode Matrix response")
+	}
+	return nil
+}
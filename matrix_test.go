@@ -0,0 +1,92 @@
+package hnoss
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatrixChatAdapter(t *testing.T) {
+	require.NoError(t, os.RemoveAll("run/matrix-sync"))
+	syncs := make(chan string, 10)
+	sends := make(chan map[string]any, 1)
+	mention := `{"type":"m.room.message","event_id":"$e","sender":"@user:example.org",
+		"content":{"msgtype":"m.text","body":"hnoss: history 3","m.mentions":{"user_ids":["@hnoss:example.org"]}}}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		path := strings.TrimPrefix(r.URL.Path, matrixClientPath)
+		switch {
+		case path == "account/whoami":
+			_, _ = w.Write([]byte(`{"user_id":"@hnoss:example.org"}`))
+		case path == "directory/room/#valheim:example.org":
+			_, _ = w.Write([]byte(`{"room_id":"!room:example.org"}`))
+		case path == "sync":
+			since := r.URL.Query().Get("since")
+			syncs <- since
+			switch since {
+			case "":
+				// Old mention must be skipped.
+				_, _ = w.Write([]byte(`{"next_batch":"s1","rooms":{"join":{"!room:example.org":{"timeline":{"events":[` +
+					mention + `]}}}}}`))
+			case "s1":
+				_, _ = w.Write([]byte(`{"next_batch":"s2","rooms":{"join":{"!room:example.org":{"timeline":{"events":[` +
+					mention + `]}}}}}`))
+			default:
+				<-r.Context().Done()
+			}
+		case strings.HasPrefix(path, "rooms/!room:example.org/send/m.room.message/"):
+			var body map[string]any
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			sends <- body
+			_, _ = w.Write([]byte(`{"event_id":"$sent"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errcode":"M_UNRECOGNIZED","error":"Unrecognized request"}`))
+		}
+	}))
+	defer server.Close()
+
+	m := NewMatrixChatAdapter(server.URL+"/", "token", "#valheim:example.org", "run/matrix-sync")
+	err := m.Listen()
+	var info *Info
+	assert.ErrorAs(t, err, &info)
+	assert.Equal(t, "", <-syncs)
+	assert.Equal(t, "s1", <-syncs)
+
+	ev := <-m.Chan()
+	assert.Equal(t, "!room:example.org", ev.ChanID)
+	assert.Equal(t, "@user:example.org", ev.AuthorID)
+	assert.Equal(t, "history", ev.Command)
+	assert.Equal(t, []string{"3"}, ev.Args)
+
+	assert.Equal(t, "s2", <-syncs)
+	require.NoError(t, m.Close())
+	b, err := os.ReadFile("run/matrix-sync")
+	require.NoError(t, err)
+	assert.Equal(t, "s2", string(b))
+
+	require.NoError(t, ev.Reply("1.2.3.4"))
+	body := <-sends
+	assert.Equal(t, "1.2.3.4", body["body"])
+	assert.Equal(t, map[string]any{"m.in_reply_to": map[string]any{"event_id": "$e"}}, body["m.relates_to"])
+
+	// Restart resumes from persisted sync token.
+	m = NewMatrixChatAdapter(server.URL, "token", "#valheim:example.org", "run/matrix-sync")
+	assert.ErrorAs(t, m.Listen(), &info)
+	assert.Equal(t, "s2", <-syncs)
+	var warn *Warn
+	assert.ErrorAs(t, m.Listen(), &warn)
+
+	require.NoError(t, m.Post("", "5.6.7.8"))
+	body = <-sends
+	assert.Equal(t, "5.6.7.8", body["body"])
+	assert.Nil(t, body["m.relates_to"])
+	require.NoError(t, m.Close())
+}
@@ -0,0 +1,137 @@
+package hnoss
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type (
+	// MessageData is available to message templates, see Config.MessageTemplates.
+	MessageData struct {
+		Kind AnnouncementKind
+		// IP is the current IP address, OldIP the previous one, if it just changed.
+		IP, OldIP netip.Addr
+		// IPv4 and IPv6 are the current IP address of each family, empty if unknown.
+		IPv4, IPv6 string
+		// Changed is when the IP address last changed, zero if never recorded.
+		Changed  time.Time
+		Hostname string
+		Ports    []int
+		Uptime   time.Duration
+		// Error describes the failure, for error announcements.
+		Error string
+		// Changes are the changes of the last day, newest first, for digests.
+		Changes []*Change
+	}
+)
+
+const (
+	// StartupAnnouncement is made when the scheduler starts, if it has a message template.
+	StartupAnnouncement AnnouncementKind = "startup"
+	// ShutdownAnnouncement is made when the scheduler exits, if it has a message template.
+	ShutdownAnnouncement AnnouncementKind = "shutdown"
+	// RecoveredAnnouncement is made when getting the IP address succeeds after failing, if it has a message template.
+	RecoveredAnnouncement AnnouncementKind = "recovered"
+)
+
+// messageKinds are the kinds of message that may be templated.
+var messageKinds = []AnnouncementKind{ChangeAnnouncement, ReplyAnnouncement, StartupAnnouncement,
+	ShutdownAnnouncement, ErrorAnnouncement, RecoveredAnnouncement}
+
+// parseMessageTemplates parses the templates in src, keyed by AnnouncementKind, and checks they can be executed.
+func parseMessageTemplates(src map[string]string) (map[AnnouncementKind]*template.Template, error) {
+	if len(src) == 0 {
+		return nil, nil
+	}
+	templates := make(map[AnnouncementKind]*template.Template, len(src))
+	for name, text := range src {
+		kind := AnnouncementKind(name)
+		if !containsKind(messageKinds, kind) {
+			return nil, Errorf("config: unknown message template: %s", name)
+		}
+		tmpl, err := parseMessageTemplate(name, text, kind)
+		if err != nil {
+			return nil, prefixError(err, "config")
+		}
+		templates[kind] = tmpl
+	}
+	return templates, nil
+}
+
+// parseMessageTemplate parses text as the template name and checks it can be executed with the data of kind.
+func parseMessageTemplate(name, text string, kind AnnouncementKind) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, ErrorWrapf(err, "failed to parse %s message template", name)
+	}
+	// Parsing doesn't catch references to fields that don't exist.
+	sample := &MessageData{Kind: kind, IP: netip.MustParseAddr("192.0.2.1"), Ports: []int{2456}}
+	if err = tmpl.Execute(&strings.Builder{}, sample); err != nil {
+		return nil, ErrorWrapf(err, "invalid %s message template", name)
+	}
+	return tmpl, nil
+}
+
+func containsKind(kinds []AnnouncementKind, kind AnnouncementKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// message renders the message for a in the locale of ev, with its template if there is one, otherwise the default for
+// its kind, which is empty for kinds that are only announced if templated.
+func (h *Hnoss) message(ev *ChatEvent, a *Announcement, errMsg string) string {
+	tmpl, ok := h.config.LocaleTemplates[h.locale(ev)][a.Kind]
+	if !ok {
+		tmpl, ok = h.config.MessageTemplates[a.Kind]
+	}
+	if ok {
+		var b strings.Builder
+		err := tmpl.Execute(&b, h.messageData(a, errMsg, nil))
+		if err == nil {
+			return b.String()
+		}
+		h.logger.Log(ErrorWrapf(err, "failed to execute %s message template", a.Kind))
+	}
+	switch a.Kind {
+	case ChangeAnnouncement, ReplyAnnouncement:
+		format, ok := h.lookup(ev, msgIPMessage)
+		if !ok {
+			format = h.config.IPMessageFormat
+		}
+		return fmt.Sprintf(format, a.IP.String())
+	case ErrorAnnouncement:
+		return h.tr(ev, msgError, errMsg)
+	default:
+		return ""
+	}
+}
+
+func (h *Hnoss) messageData(a *Announcement, errMsg string, changes []*Change) *MessageData {
+	d := &MessageData{
+		Kind:    a.Kind,
+		IP:      a.IP,
+		OldIP:   a.OldIP,
+		Changed: h.changed,
+		Ports:   h.config.Ports,
+		Error:   errMsg,
+		Changes: changes,
+	}
+	d.Hostname, _ = os.Hostname()
+	if !h.started.Equal(zeroTime) {
+		d.Uptime = a.Time.Sub(h.started).Round(time.Second)
+	}
+	if a.IP.Is4() || a.IP.Is4In6() {
+		d.IPv4 = a.IP.Unmap().String()
+	} else if a.IP.Is6() {
+		d.IPv6 = a.IP.String()
+	}
+	return d
+}
@@ -0,0 +1,85 @@
+package hnoss
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessageTemplates(t *testing.T) {
+	testCases := []struct {
+		description string
+		src         map[string]string
+		xErr        string
+	}{
+		{"Valid", map[string]string{"change": "{{.IP}}{{range .Ports}} :{{.}}{{end}}", "error": "{{.Error}}"}, ""},
+		{"UnknownKind", map[string]string{"changed": "{{.IP}}"},
+			"ERROR: config: unknown message template: changed"},
+		{"ParseError", map[string]string{"reply": "{{.IP"},
+			"ERROR: config: failed to parse reply message template: template: reply:1: unclosed action"},
+		{"UnknownField", map[string]string{"startup": "{{.Port}}"},
+			"ERROR: config: invalid startup message template: template: startup:1:2: executing \"startup\" at " +
+				"<.Port>: can't evaluate field Port in type *hnoss.MessageData"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			templates, err := parseMessageTemplates(tc.src)
+			if tc.xErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, tc.xErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, templates, len(tc.src))
+		})
+	}
+}
+
+func TestMessageTemplates(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	templates, err := parseMessageTemplates(map[string]string{
+		"change":    "{{.OldIP}} -> {{.IPv4}}{{range .Ports}} :{{.}}{{end}} on {{.Hostname}}",
+		"recovered": "back after {{.Uptime}}",
+		"startup":   "{{.Kind}}",
+	})
+	require.NoError(t, err)
+	ipService := &mockIPAdaptor{err: NewError("An error")}
+	announcer := &mockAnnouncerChatAdaptor{}
+	conf := &Config{IPMessageFormat: "%s:2456", MessageTemplates: templates, Ports: []int{2456, 2457}}
+	h := New(conf, logger, &mockTimeAdaptor{}, ipService, &mockIPAdaptor{}, &mockHistoryAdaptor{}, nil, nil,
+		announcer, nil)
+	h.ip = newIP(t, "1.2.3.4")
+	h.started = newTime(t, "2023-11-28T12:00:00Z")
+	now := newTime(t, "2023-11-28T14:00:00Z")
+
+	h.announceEvent(now, StartupAnnouncement)
+	assert.Equal(t, "startup", announcer.announcement.Message)
+	announcer.announcement = nil
+	h.announceEvent(now, ShutdownAnnouncement)
+	assert.Nil(t, announcer.announcement)
+
+	// Errors aren't announced without AnnounceErrors, but recovery is still announced.
+	h.run(now, false, nil)
+	assert.Nil(t, announcer.announcement)
+	ipService.err = nil
+	ipService.ip = newIP(t, "5.6.7.8")
+	h.run(now, true, nil)
+	assert.Equal(t, RecoveredAnnouncement, announcer.announcement.Kind)
+	assert.Equal(t, "back after 2h0m0s", announcer.announcement.Message)
+
+	h.run(now, false, nil)
+	hostname, _ := os.Hostname()
+	assert.Equal(t, ChangeAnnouncement, announcer.announcement.Kind)
+	assert.Equal(t, "1.2.3.4 -> 5.6.7.8 :2456 :2457 on "+hostname, announcer.announcement.Message)
+
+	// Without a template replies use IPMessageFormat.
+	var reply string
+	h.run(now, true, &ChatEvent{Reply: func(msg string) error {
+		reply = msg
+		return nil
+	}})
+	assert.Equal(t, "5.6.7.8:2456", reply)
+}
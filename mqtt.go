@@ -0,0 +1,486 @@
+package hnoss
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type (
+	// MQTTChatAdapter publishes the bot's status to an MQTT broker as retained messages, with Home Assistant MQTT
+	// discovery configs, and treats messages on its command topic like chat mentions.
+	MQTTChatAdapter struct {
+		addr string
+		tls  bool
+		opts MQTTOptions
+
+		status    *Status
+		c         chan *ChatEvent
+		conn      *mqttConn
+		tlsConfig *tls.Config
+		mu        sync.Mutex
+	}
+	// MQTTOptions configures optional MQTTChatAdapter behaviour.
+	MQTTOptions struct {
+		// ClientID also identifies the Home Assistant device, defaults to "hnoss".
+		ClientID string
+		Username string
+		Password string
+		// Topic is the prefix of the bot's topics, defaults to "hnoss".
+		Topic string
+		// DiscoveryPrefix is Home Assistant's discovery prefix, defaults to "homeassistant".
+		DiscoveryPrefix string
+	}
+	mqttConn struct {
+		net.Conn
+		r      *bufio.Reader
+		done   chan struct{}
+		mu     sync.Mutex
+		closed bool
+	}
+	mqttPacket struct {
+		typ, flags byte
+		body       []byte
+	}
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	mqttConnect    = 1
+	mqttConnAck    = 2
+	mqttPublish    = 3
+	mqttSubscribe  = 8
+	mqttSubAck     = 9
+	mqttPingReq    = 12
+	mqttPingResp   = 13
+	mqttDisconnect = 14
+)
+
+const (
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+	mqttHealthy = "ok"
+	mqttFailing = "error"
+)
+
+// mqttKeepAlive is the keep alive interval sent to the broker, the adapter pings at half this interval.
+var mqttKeepAlive = 60 * time.Second
+
+// NewMQTTChatAdapter takes the broker as a URL, tcp://host:port or mqtt://host:port, or ssl://, tls:// or mqtts://
+// for TLS.
+func NewMQTTChatAdapter(broker string, opts MQTTOptions) (*MQTTChatAdapter, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, FatalWrap(err, "config: failed to parse MQTT broker URL")
+	}
+	var useTLS bool
+	port := "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		useTLS = true
+		port = "8883"
+	default:
+		return nil, Fatalf("config: unknown MQTT broker URL scheme: %s", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "hnoss"
+	}
+	if opts.Topic == "" {
+		opts.Topic = "hnoss"
+	}
+	if opts.DiscoveryPrefix == "" {
+		opts.DiscoveryPrefix = "homeassistant"
+	}
+	return &MQTTChatAdapter{
+		addr:      net.JoinHostPort(u.Hostname(), port),
+		tls:       useTLS,
+		opts:      opts,
+		c:         make(chan *ChatEvent),
+		tlsConfig: &tls.Config{ServerName: u.Hostname()},
+	}, nil
+}
+
+func (m *MQTTChatAdapter) Chan() <-chan *ChatEvent {
+	return m.c
+}
+
+func (m *MQTTChatAdapter) topic(name string) string {
+	return m.opts.Topic + "/" + name
+}
+
+// Listen connects to the broker, publishes discovery configs and availability, and subscribes to the command topic.
+func (m *MQTTChatAdapter) Listen() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn != nil && !m.conn.isClosed() {
+		return NewWarn("MQTT already connected")
+	}
+	c, err := m.connect()
+	if err != nil {
+		return err
+	}
+	m.conn = c
+	if err = m.publishDiscovery(c); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if m.status != nil {
+		if err = m.publishStatus(c, m.status); err != nil {
+			_ = c.Close()
+			return err
+		}
+	}
+	go m.read(c)
+	go m.ping(c)
+	return NewInfo("connected to MQTT")
+}
+
+func (m *MQTTChatAdapter) connect() (*mqttConn, error) {
+	var conn net.Conn
+	var err error
+	if m.tls {
+		conn, err = tls.Dial("tcp", m.addr, m.tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", m.addr)
+	}
+	if err != nil {
+		return nil, ErrorWrapf(err, "failed to connect to MQTT broker: %s", m.addr)
+	}
+	c := &mqttConn{Conn: conn, r: bufio.NewReader(conn), done: make(chan struct{})}
+
+	// Clean session, with a retained QoS 1 will marking the bot offline.
+	flags := byte(0x02 | 0x04 | 1<<3 | 0x20)
+	var payload []byte
+	payload = mqttAppendString(payload, m.opts.ClientID)
+	payload = mqttAppendString(payload, m.topic("availability"))
+	payload = mqttAppendString(payload, mqttOffline)
+	if m.opts.Username != "" {
+		flags |= 0x80
+		payload = mqttAppendString(payload, m.opts.Username)
+		if m.opts.Password != "" {
+			flags |= 0x40
+			payload = mqttAppendString(payload, m.opts.Password)
+		}
+	}
+	body := mqttAppendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = append(body, payload...)
+
+	_ = conn.SetDeadline(time.Now().Add(mqttKeepAlive))
+	if err = c.send(&mqttPacket{typ: mqttConnect, body: body}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	p, err := readMQTTPacket(c.r)
+	if err != nil {
+		_ = conn.Close()
+		return nil, ErrorWrap(err, "failed to read MQTT CONNACK")
+	}
+	if p.typ != mqttConnAck || len(p.body) < 2 {
+		_ = conn.Close()
+		return nil, Errorf("unexpected MQTT packet type: %d", p.typ)
+	}
+	if p.body[1] != 0 {
+		_ = conn.Close()
+		return nil, Errorf("MQTT connection refused: return code %d", p.body[1])
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	sub := binary.BigEndian.AppendUint16(nil, 1)
+	sub = append(mqttAppendString(sub, m.topic("command")), 0)
+	// Home Assistant announces itself on its status topic when it starts, discovery configs should be resent.
+	sub = append(mqttAppendString(sub, m.opts.DiscoveryPrefix+"/status"), 0)
+	if err = c.send(&mqttPacket{typ: mqttSubscribe, flags: 0x02, body: sub}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// read packets from c until it's closed, sending a ChatEvent for each command.
+func (m *MQTTChatAdapter) read(c *mqttConn) {
+	defer c.Close()
+	for {
+		// Something, if only a PINGRESP, should arrive every half keep alive interval.
+		_ = c.SetReadDeadline(time.Now().Add(mqttKeepAlive))
+		p, err := readMQTTPacket(c.r)
+		if err != nil {
+			return
+		}
+		if p.typ != mqttPublish {
+			continue
+		}
+		topic, payload, err := p.publish()
+		if err != nil {
+			return
+		}
+		switch topic {
+		case m.topic("command"):
+			select {
+			case m.c <- m.newChatEvent(payload):
+			case <-c.done:
+				return
+			}
+		case m.opts.DiscoveryPrefix + "/status":
+			if payload == mqttOnline {
+				m.mu.Lock()
+				err = m.publishDiscovery(c)
+				if err == nil && m.status != nil {
+					err = m.publishStatus(c, m.status)
+				}
+				m.mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (m *MQTTChatAdapter) ping(c *mqttConn) {
+	ticker := time.NewTicker(mqttKeepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if c.send(&mqttPacket{typ: mqttPingReq}) != nil {
+				_ = c.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// newChatEvent parses a command payload, an empty payload is a refresh.
+func (m *MQTTChatAdapter) newChatEvent(payload string) *ChatEvent {
+	ev := &ChatEvent{
+		ChanID: m.topic("command"),
+		Text:   payload,
+		Reply: func(msg string) error {
+			return m.Post("", msg)
+		},
+	}
+	ev.Command, ev.Args = ParseCommand(payload)
+	if ev.Command == "" {
+		ev.Command = refreshCommand
+	}
+	return ev
+}
+
+func (m *MQTTChatAdapter) publishDiscovery(c *mqttConn) error {
+	device := map[string]any{"identifiers": []string{m.opts.ClientID}, "name": m.opts.ClientID}
+	configs := []struct {
+		component, object string
+		config            map[string]any
+	}{
+		{"sensor", "ip", map[string]any{
+			"name":        "IP address",
+			"state_topic": m.topic("ip"),
+			"icon":        "mdi:ip-network",
+		}},
+		{"sensor", "changed", map[string]any{
+			"name":         "IP address changed",
+			"state_topic":  m.topic("changed"),
+			"device_class": "timestamp",
+		}},
+		{"binary_sensor", "health", map[string]any{
+			"name":         "Health",
+			"state_topic":  m.topic("health"),
+			"device_class": "problem",
+			"payload_on":   mqttFailing,
+			"payload_off":  mqttHealthy,
+		}},
+		{"button", "refresh", map[string]any{
+			"name":          "Refresh",
+			"command_topic": m.topic("command"),
+			"payload_press": refreshCommand,
+		}},
+	}
+	for _, d := range configs {
+		d.config["unique_id"] = m.opts.ClientID + "_" + d.object
+		d.config["availability_topic"] = m.topic("availability")
+		d.config["device"] = device
+		b, err := json.Marshal(d.config)
+		if err != nil {
+			return ErrorWrap(err, "failed to encode Home Assistant discovery config")
+		}
+		topic := m.opts.DiscoveryPrefix + "/" + d.component + "/" + m.opts.ClientID + "/" + d.object + "/config"
+		if err = c.publish(topic, string(b), true); err != nil {
+			return err
+		}
+	}
+	return c.publish(m.topic("availability"), mqttOnline, true)
+}
+
+func (m *MQTTChatAdapter) publishStatus(c *mqttConn, s *Status) error {
+	health := mqttHealthy
+	if s.Err != nil {
+		health = mqttFailing
+	}
+	messages := []struct{ topic, payload string }{
+		{m.topic("ip"), addrString(s.IP)},
+		{m.topic("health"), health},
+	}
+	// Home Assistant rejects an empty timestamp, so it's only published once known.
+	if !s.Changed.Equal(zeroTime) {
+		messages = append(messages, struct{ topic, payload string }{m.topic("changed"),
+			s.Changed.Format(time.RFC3339)})
+	}
+	for _, msg := range messages {
+		if err := c.publish(msg.topic, msg.payload, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateStatus publishes s as retained messages, republished whenever the adapter reconnects.
+func (m *MQTTChatAdapter) UpdateStatus(s *Status) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status = s
+	if m.conn == nil || m.conn.isClosed() {
+		return NewWarn("MQTT not connected, status will be published on reconnect")
+	}
+	return m.publishStatus(m.conn, s)
+}
+
+// Post msg to the message topic, chanID is ignored.
+func (m *MQTTChatAdapter) Post(_, msg string) error {
+	m.mu.Lock()
+	c := m.conn
+	m.mu.Unlock()
+	if c == nil || c.isClosed() {
+		return NewError("MQTT not connected")
+	}
+	return c.publish(m.topic("message"), msg, false)
+}
+
+// Close marks the bot offline and disconnects cleanly, so the broker discards the will.
+func (m *MQTTChatAdapter) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil || m.conn.isClosed() {
+		return nil
+	}
+	err := m.conn.publish(m.topic("availability"), mqttOffline, true)
+	if err == nil {
+		err = m.conn.send(&mqttPacket{typ: mqttDisconnect})
+	}
+	_ = m.conn.Close()
+	m.conn = nil
+	return err
+}
+
+func (c *mqttConn) publish(topic, payload string, retain bool) error {
+	var flags byte
+	if retain {
+		flags = 0x01
+	}
+	return c.send(&mqttPacket{typ: mqttPublish, flags: flags, body: append(mqttAppendString(nil, topic), payload...)})
+}
+
+func (c *mqttConn) send(p *mqttPacket) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := writeMQTTPacket(c.Conn, p); err != nil {
+		return ErrorWrap(err, "failed to send MQTT packet")
+	}
+	return nil
+}
+
+func (c *mqttConn) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *mqttConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	return c.Conn.Close()
+}
+
+// publish returns the topic and payload of a PUBLISH packet, skipping the packet identifier if QoS > 0.
+func (p *mqttPacket) publish() (topic, payload string, err error) {
+	if len(p.body) < 2 {
+		return "", "", NewError("short MQTT PUBLISH packet")
+	}
+	n := 2 + int(binary.BigEndian.Uint16(p.body))
+	start := n
+	if p.flags&0x06 != 0 {
+		start += 2
+	}
+	if len(p.body) < start {
+		return "", "", NewError("short MQTT PUBLISH packet")
+	}
+	return string(p.body[2:n]), string(p.body[start:]), nil
+}
+
+func readMQTTPacket(r *bufio.Reader) (*mqttPacket, error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var length, shift int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return nil, NewError("malformed MQTT remaining length")
+		}
+	}
+	p := &mqttPacket{typ: h >> 4, flags: h & 0x0f, body: make([]byte, length)}
+	if _, err = io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func writeMQTTPacket(w io.Writer, p *mqttPacket) error {
+	b := []byte{p.typ<<4 | p.flags}
+	n := len(p.body)
+	for {
+		d := byte(n & 0x7f)
+		if n >>= 7; n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(b, p.body...))
+	return err
+}
+
+func mqttAppendString(b []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint16(b, uint16(len(s))), s...)
+}
@@ -0,0 +1,236 @@
+package hnoss
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	// fakeMQTTBroker accepts one client at a time, recording what it publishes and subscribes to.
+	fakeMQTTBroker struct {
+		net.Listener
+		published     chan *fakeMQTTMessage
+		subscriptions []string
+		clientID      string
+		will          *fakeMQTTMessage
+		auth          string
+		disconnected  bool
+		conn          net.Conn
+		mu            sync.Mutex
+	}
+	fakeMQTTMessage struct {
+		topic, payload string
+		retain         bool
+	}
+)
+
+func newFakeMQTTBroker(t *testing.T) *fakeMQTTBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	b := &fakeMQTTBroker{Listener: l, published: make(chan *fakeMQTTMessage, 100)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go b.handle(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	return b
+}
+
+func (b *fakeMQTTBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	b.mu.Lock()
+	b.conn = conn
+	b.disconnected = false
+	b.mu.Unlock()
+	r := bufio.NewReader(conn)
+	for {
+		p, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		switch p.typ {
+		case mqttConnect:
+			b.connect(p.body)
+			_ = writeMQTTPacket(conn, &mqttPacket{typ: mqttConnAck, body: []byte{0, 0}})
+		case mqttPublish:
+			topic, payload, _ := p.publish()
+			b.published <- &fakeMQTTMessage{topic, payload, p.flags&0x01 != 0}
+		case mqttSubscribe:
+			body := p.body[2:]
+			b.mu.Lock()
+			for len(body) > 0 {
+				var topic string
+				topic, body = fakeMQTTString(body)
+				b.subscriptions = append(b.subscriptions, topic)
+				body = body[1:]
+			}
+			b.mu.Unlock()
+			_ = writeMQTTPacket(conn, &mqttPacket{typ: mqttSubAck, body: append(p.body[:2:2], 0, 0)})
+		case mqttPingReq:
+			_ = writeMQTTPacket(conn, &mqttPacket{typ: mqttPingResp})
+		case mqttDisconnect:
+			b.mu.Lock()
+			b.disconnected = true
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (b *fakeMQTTBroker) connect(body []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, body = fakeMQTTString(body)
+	flags := body[1]
+	body = body[4:]
+	b.clientID, body = fakeMQTTString(body)
+	if flags&0x04 != 0 {
+		b.will = &fakeMQTTMessage{retain: flags&0x20 != 0}
+		b.will.topic, body = fakeMQTTString(body)
+		b.will.payload, body = fakeMQTTString(body)
+	}
+	if flags&0x80 != 0 {
+		b.auth, body = fakeMQTTString(body)
+	}
+	if flags&0x40 != 0 {
+		var password string
+		password, _ = fakeMQTTString(body)
+		b.auth += ":" + password
+	}
+}
+
+// send a message to the connected client.
+func (b *fakeMQTTBroker) send(topic, payload string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return writeMQTTPacket(b.conn, &mqttPacket{typ: mqttPublish, body: append(mqttAppendString(nil, topic),
+		payload...)})
+}
+
+// drop the client's connection, as if the network failed.
+func (b *fakeMQTTBroker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_ = b.conn.Close()
+}
+
+func fakeMQTTString(b []byte) (string, []byte) {
+	n := int(binary.BigEndian.Uint16(b))
+	return string(b[2 : 2+n]), b[2+n:]
+}
+
+// receive n messages published to the broker.
+func (b *fakeMQTTBroker) receive(t *testing.T, n int) map[string]*fakeMQTTMessage {
+	messages := map[string]*fakeMQTTMessage{}
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-b.published:
+			messages[msg.topic] = msg
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for MQTT messages", "received %d of %d", i, n)
+		}
+	}
+	return messages
+}
+
+func TestMQTTChatAdapter(t *testing.T) {
+	b := newFakeMQTTBroker(t)
+	m, err := NewMQTTChatAdapter("tcp://"+b.Addr().String(), MQTTOptions{Username: "user", Password: "pass"})
+	require.NoError(t, err)
+
+	var info *Info
+	assert.ErrorAs(t, m.Listen(), &info)
+	messages := b.receive(t, 5)
+	assert.Equal(t, &fakeMQTTMessage{"hnoss/availability", "online", true}, messages["hnoss/availability"])
+	msg := messages["homeassistant/sensor/hnoss/ip/config"]
+	require.NotNil(t, msg)
+	assert.True(t, msg.retain)
+	assert.JSONEq(t, `{"name":"IP address","state_topic":"hnoss/ip","icon":"mdi:ip-network","unique_id":"hnoss_ip",
+		"availability_topic":"hnoss/availability","device":{"identifiers":["hnoss"],"name":"hnoss"}}`, msg.payload)
+	var button map[string]any
+	require.Contains(t, messages, "homeassistant/button/hnoss/refresh/config")
+	require.NoError(t, json.Unmarshal([]byte(messages["homeassistant/button/hnoss/refresh/config"].payload), &button))
+	assert.Equal(t, "hnoss/command", button["command_topic"])
+	assert.Contains(t, messages, "homeassistant/sensor/hnoss/changed/config")
+	assert.Contains(t, messages, "homeassistant/binary_sensor/hnoss/health/config")
+
+	b.mu.Lock()
+	assert.Equal(t, "hnoss", b.clientID)
+	assert.Equal(t, "user:pass", b.auth)
+	assert.Equal(t, &fakeMQTTMessage{"hnoss/availability", "offline", true}, b.will)
+	assert.Equal(t, []string{"hnoss/command", "homeassistant/status"}, b.subscriptions)
+	b.mu.Unlock()
+
+	var warn *Warn
+	assert.ErrorAs(t, m.Listen(), &warn)
+
+	changed, err := time.Parse(time.RFC3339, "2023-11-28T00:00:00Z")
+	require.NoError(t, err)
+	status := &Status{IP: netip.MustParseAddr("1.2.3.4"), Changed: changed}
+	require.NoError(t, m.UpdateStatus(status))
+	messages = b.receive(t, 3)
+	assert.Equal(t, &fakeMQTTMessage{"hnoss/ip", "1.2.3.4", true}, messages["hnoss/ip"])
+	assert.Equal(t, &fakeMQTTMessage{"hnoss/changed", "2023-11-28T00:00:00Z", true}, messages["hnoss/changed"])
+	assert.Equal(t, &fakeMQTTMessage{"hnoss/health", "ok", true}, messages["hnoss/health"])
+
+	status.Err = NewError("An error")
+	require.NoError(t, m.UpdateStatus(status))
+	messages = b.receive(t, 3)
+	assert.Equal(t, "error", messages["hnoss/health"].payload)
+
+	// Commands arrive like chat mentions, an empty command is a refresh.
+	require.NoError(t, b.send("hnoss/command", ""))
+	ev := <-m.Chan()
+	assert.Equal(t, refreshCommand, ev.Command)
+	require.NoError(t, ev.Reply("1.2.3.4"))
+	assert.Equal(t, &fakeMQTTMessage{"hnoss/message", "1.2.3.4", false}, b.receive(t, 1)["hnoss/message"])
+	require.NoError(t, b.send("hnoss/command", "history 2"))
+	ev = <-m.Chan()
+	assert.Equal(t, historyCommand, ev.Command)
+	assert.Equal(t, []string{"2"}, ev.Args)
+
+	// Home Assistant restarted, discovery and status are republished.
+	require.NoError(t, b.send("homeassistant/status", "online"))
+	messages = b.receive(t, 8)
+	assert.Contains(t, messages, "homeassistant/sensor/hnoss/ip/config")
+	assert.Equal(t, "1.2.3.4", messages["hnoss/ip"].payload)
+
+	// Reconnect after the connection drops, republishing status.
+	b.drop()
+	assert.Eventually(t, func() bool {
+		return m.Post("", "") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.ErrorAs(t, m.Listen(), &info)
+	messages = b.receive(t, 8)
+	assert.Equal(t, "online", messages["hnoss/availability"].payload)
+	assert.Equal(t, "error", messages["hnoss/health"].payload)
+
+	require.NoError(t, m.Close())
+	assert.Equal(t, &fakeMQTTMessage{"hnoss/availability", "offline", true}, b.receive(t, 1)["hnoss/availability"])
+	assert.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.disconnected
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, m.Close())
+
+	_, err = NewMQTTChatAdapter("http://localhost", MQTTOptions{})
+	var fatal *Fatal
+	assert.ErrorAs(t, err, &fatal)
+}
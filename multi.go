@@ -0,0 +1,234 @@
+package hnoss
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// multiTimeout limits how long MultiChatAdapter waits for its adapters, longer than httpTimeout so that an adapter's
+// own timeout is reported first.
+var multiTimeout = httpTimeout + 10*time.Second
+
+type (
+	// MultiChatAdapter fans out to several chat adapters at once. Events from every adapter are merged into one
+	// channel, with ChanID prefixed by the adapter's name, e.g. "discord:1234", and replies are routed back to the
+	// adapter the event came from. Announcements go to every adapter, a failure of one doesn't stop the others.
+	MultiChatAdapter struct {
+		names    []string
+		adapters map[string]ChatAdapter
+		logger   *Logger
+
+		c          chan *ChatEvent
+		stop, done chan struct{}
+		mu         sync.Mutex
+	}
+)
+
+// NewMultiChatAdapter takes adapters by name. Listen logs the errors of individual adapters to logger, only failing
+// if none of them are listening.
+func NewMultiChatAdapter(logger *Logger, adapters map[string]ChatAdapter) *MultiChatAdapter {
+	names := make([]string, 0, len(adapters))
+	for name := range adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &MultiChatAdapter{
+		names:    names,
+		adapters: adapters,
+		logger:   logger,
+		c:        make(chan *ChatEvent),
+	}
+}
+
+func (m *MultiChatAdapter) Chan() <-chan *ChatEvent {
+	return m.c
+}
+
+func (m *MultiChatAdapter) Listen() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop == nil {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		var wg sync.WaitGroup
+		for _, name := range m.names {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				m.forward(name, m.stop)
+			}(name)
+		}
+		go func(done chan struct{}) {
+			wg.Wait()
+			close(done)
+		}(m.done)
+	}
+
+	failed := 0
+	for _, name := range m.names {
+		err := m.adapters[name].Listen()
+		var w *Warn
+		if err == nil || errors.As(err, &w) {
+			continue
+		}
+		m.logger.Log(prefixError(err, name))
+		var e *Error
+		var f *Fatal
+		if errors.As(err, &e) || errors.As(err, &f) {
+			failed++
+		}
+	}
+	if failed == len(m.names) {
+		return NewError("no chat adapter listening")
+	}
+	return nil
+}
+
+// forward events from the adapter name until stop is closed.
+func (m *MultiChatAdapter) forward(name string, stop chan struct{}) {
+	a := m.adapters[name]
+	for {
+		select {
+		case ev := <-a.Chan():
+			chanID := ev.ChanID
+			ev.ChanID = name + ":" + chanID
+			if ev.Reply == nil {
+				ev.Reply = func(msg string) error {
+					return a.Post(chanID, msg)
+				}
+			}
+			select {
+			case m.c <- ev:
+			case <-stop:
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (m *MultiChatAdapter) Close() error {
+	m.mu.Lock()
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+		m.stop = nil
+	}
+	m.mu.Unlock()
+	var err error
+	for _, name := range m.names {
+		if aErr := m.adapters[name].Close(); aErr != nil {
+			multiError(&err, prefixError(aErr, name))
+		}
+	}
+	return err
+}
+
+// Post msg to every adapter's default channel if chanID is empty, otherwise to the adapter and channel identified
+// by chanID, of the form "<adapter name>:<channel ID>".
+func (m *MultiChatAdapter) Post(chanID, msg string) error {
+	if chanID == "" {
+		return m.each(func(a ChatAdapter) error {
+			return a.Post("", msg)
+		})
+	}
+	name, id, _ := strings.Cut(chanID, ":")
+	a, ok := m.adapters[name]
+	if !ok {
+		return Errorf("unknown chat adapter: %s", name)
+	}
+	return a.Post(id, msg)
+}
+
+func (m *MultiChatAdapter) Announce(an *Announcement) error {
+	return m.each(func(a ChatAdapter) error {
+		if announcer, ok := a.(Announcer); ok {
+			return announcer.Announce(an)
+		}
+		return a.Post("", an.Message)
+	})
+}
+
+// AnnounceChannel routes an announcement to a subscribed channel to the adapter identified by an.ChanID, as Post.
+func (m *MultiChatAdapter) AnnounceChannel(an *Announcement) error {
+	name, id, _ := strings.Cut(an.ChanID, ":")
+	a, ok := m.adapters[name]
+	if !ok {
+		return Errorf("unknown chat adapter: %s", name)
+	}
+	c := *an
+	c.ChanID = id
+	if announcer, ok := a.(ChannelAnnouncer); ok {
+		return announcer.AnnounceChannel(&c)
+	}
+	return a.Post(id, c.Message)
+}
+
+func (m *MultiChatAdapter) SetCommands(commands []*Command) {
+	for _, name := range m.names {
+		if setter, ok := m.adapters[name].(CommandSetter); ok {
+			setter.SetCommands(commands)
+		}
+	}
+}
+
+func (m *MultiChatAdapter) UpdateStatus(s *Status) error {
+	return m.each(func(a ChatAdapter) error {
+		if updater, ok := a.(StatusUpdater); ok {
+			return updater.UpdateStatus(s)
+		}
+		return nil
+	})
+}
+
+// QueueDepth returns the total number of announcements waiting to be sent by adapters that queue them.
+func (m *MultiChatAdapter) QueueDepth() int {
+	depth := 0
+	for _, name := range m.names {
+		if q, ok := m.adapters[name].(Queue); ok {
+			depth += q.QueueDepth()
+		}
+	}
+	return depth
+}
+
+// each calls f with every adapter at once, returning the errors of all that fail, in name order. Adapters still busy
+// after multiTimeout are left to finish in the background and reported with a warning.
+func (m *MultiChatAdapter) each(f func(ChatAdapter) error) error {
+	// Concurrently, so one adapter that hangs doesn't hold up delivery to the others.
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(m.names))
+	for i, name := range m.names {
+		go func(i int, a ChatAdapter) {
+			results <- result{i, f(a)}
+		}(i, m.adapters[name])
+	}
+	errs := make([]error, len(m.names))
+	done := make([]bool, len(m.names))
+	timeout := time.NewTimer(multiTimeout)
+	defer timeout.Stop()
+	for pending := len(m.names); pending > 0; pending-- {
+		select {
+		case r := <-results:
+			errs[r.i], done[r.i] = r.err, true
+		case <-timeout.C:
+			pending = 0
+		}
+	}
+	var err error
+	for i, name := range m.names {
+		if !done[i] {
+			multiError(&err, prefixError(Warnf("still busy after %s", multiTimeout), name))
+		} else if errs[i] != nil {
+			multiError(&err, prefixError(errs[i], name))
+		}
+	}
+	return err
+}
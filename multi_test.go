@@ -0,0 +1,152 @@
+package hnoss
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingChatAdaptor struct {
+	mockChatAdaptor
+}
+
+func (m *failingChatAdaptor) Post(string, string) error {
+	return NewError("outage")
+}
+
+// blockingChatAdaptor sends each message posted to posted, then waits for release to be closed.
+type blockingChatAdaptor struct {
+	mockChatAdaptor
+	posted  chan string
+	release chan struct{}
+}
+
+func (m *blockingChatAdaptor) Post(_, msg string) error {
+	m.posted <- msg
+	<-m.release
+	return nil
+}
+
+func TestMultiChatAdapterHung(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	hung := &blockingChatAdaptor{posted: make(chan string, 1), release: make(chan struct{})}
+	ok := &blockingChatAdaptor{posted: make(chan string, 1), release: make(chan struct{})}
+	close(ok.release)
+	m := NewMultiChatAdapter(logger, map[string]ChatAdapter{"a": hung, "b": ok})
+
+	done := make(chan error)
+	go func() {
+		done <- m.Post("", "1.2.3.4")
+	}()
+	// b is posted to while a hangs.
+	select {
+	case msg := <-ok.posted:
+		assert.Equal(t, "1.2.3.4", msg)
+	case <-time.After(time.Second):
+		t.Fatal("post held up by hung adapter")
+	}
+	assert.Equal(t, "1.2.3.4", <-hung.posted)
+	close(hung.release)
+	assert.NoError(t, <-done)
+
+	// Nor does Post wait for an adapter that stays hung.
+	defer func(d time.Duration) {
+		multiTimeout = d
+	}(multiTimeout)
+	multiTimeout = time.Millisecond
+	hung.release = make(chan struct{})
+	var warn *Warn
+	assert.ErrorAs(t, m.Post("", "5.6.7.8"), &warn)
+	assert.Equal(t, "5.6.7.8", <-ok.posted)
+	assert.Equal(t, "5.6.7.8", <-hung.posted)
+	close(hung.release)
+}
+
+func TestMultiChatAdapter(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	discord := &mockChatAdaptor{c: make(chan *ChatEvent)}
+	matrix := &mockAnnouncerChatAdaptor{mockChatAdaptor: mockChatAdaptor{c: make(chan *ChatEvent)}}
+	slack := &failingChatAdaptor{mockChatAdaptor{c: make(chan *ChatEvent), err: NewError("outage")}}
+	m := NewMultiChatAdapter(logger, map[string]ChatAdapter{"discord": discord, "matrix": matrix, "slack": slack})
+
+	// One adapter failing to listen doesn't fail the rest.
+	assert.NoError(t, m.Listen())
+
+	// Replies go back to the originating adapter.
+	discord.c <- &ChatEvent{ChanID: "1234", Command: "ip"}
+	ev := <-m.Chan()
+	assert.Equal(t, "discord:1234", ev.ChanID)
+	require.NoError(t, ev.Reply("1.2.3.4"))
+	assert.Equal(t, "1234", discord.postChanID)
+	assert.Equal(t, "1.2.3.4", discord.postMsg)
+	assert.Equal(t, "", matrix.postMsg)
+
+	var replied string
+	matrix.c <- &ChatEvent{ChanID: "!room", Reply: func(msg string) error {
+		replied = msg
+		return nil
+	}}
+	ev = <-m.Chan()
+	assert.Equal(t, "matrix:!room", ev.ChanID)
+	require.NoError(t, ev.Reply("5.6.7.8"))
+	assert.Equal(t, "5.6.7.8", replied)
+
+	require.NoError(t, m.Post("matrix:!other", "hello"))
+	assert.Equal(t, "!other", matrix.postChanID)
+	assert.Error(t, m.Post("irc:#hnoss", "hello"))
+
+	// Announcements to subscribed channels are routed like posts.
+	require.NoError(t, m.AnnounceChannel(&Announcement{ChanID: "discord:5678", Message: "hi"}))
+	assert.Equal(t, "5678", discord.postChanID)
+	assert.Equal(t, "hi", discord.postMsg)
+	assert.Error(t, m.AnnounceChannel(&Announcement{ChanID: "irc:#hnoss", Message: "hi"}))
+
+	// Announcements reach every adapter despite the Slack outage.
+	a := &Announcement{Message: "9.10.11.12"}
+	err = m.Announce(a)
+	assert.EqualError(t, err, "ERROR: slack: outage")
+	assert.Equal(t, "9.10.11.12", discord.postMsg)
+	assert.Equal(t, "", discord.postChanID)
+	assert.Equal(t, a, matrix.announcement)
+
+	discord.err = NewError("outage")
+	matrix.err = NewError("outage")
+	assert.Error(t, m.Listen())
+
+	assert.NoError(t, m.Close())
+}
+
+func TestNewChatAdapter(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	conf := &Config{
+		ChatAdapters: []string{"webhook", "ntfy"},
+		WebhookURL:   "http://localhost",
+		PushURL:      "http://localhost",
+		PushTopic:    "hnoss",
+	}
+	a, err := NewChatAdapter(conf, logger)
+	require.NoError(t, err)
+	m, ok := a.(*MultiChatAdapter)
+	require.True(t, ok)
+	assert.Equal(t, []string{"ntfy", "webhook"}, m.names)
+
+	// Each adapter gets its own outbox.
+	conf.OutboxFile = "run/outbox"
+	a, err = NewChatAdapter(conf, logger)
+	require.NoError(t, err)
+	m = a.(*MultiChatAdapter)
+	o, ok := m.adapters["ntfy"].(*Outbox)
+	require.True(t, ok)
+	assert.Equal(t, "run/outbox-ntfy", o.opts.File)
+	assert.Equal(t, 0, m.QueueDepth())
+
+	conf.ChatAdapters = []string{"webhook", "webhook"}
+	_, err = NewChatAdapter(conf, logger)
+	var fatal *Fatal
+	assert.ErrorAs(t, err, &fatal)
+}
@@ -0,0 +1,403 @@
+package hnoss
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type (
+	// Outbox queues the announcements made to a ChatAdapter, to its default channel or subscribed ones, persisting
+	// them to disk, and sends them in the background, retrying any that fail with backoff until they're sent or
+	// expire. Everything else is passed straight through to the ChatAdapter.
+	Outbox struct {
+		ChatAdapter
+		logger  *Logger
+		opts    OutboxOptions
+		now     func() time.Time
+		mu      sync.Mutex
+		entries []*outboxEntry
+		loaded  bool
+		timer   *time.Timer
+		closed  bool
+		// flushed is closed when flush returns, nil if it isn't running.
+		flushed chan struct{}
+		// sending is the entry flush is sending, which mustn't be changed, nil if none.
+		sending *outboxEntry
+		// status is the last Status, passed on again when sending changes the queue depth.
+		status *Status
+	}
+	// OutboxOptions configures an Outbox.
+	OutboxOptions struct {
+		// File persists the queue across restarts, if empty the queue is only kept in memory.
+		File string
+		// TTL is how long an announcement is retried before it's dropped, forever if zero.
+		TTL time.Duration
+	}
+	outboxEntry struct {
+		Announcement *Announcement `json:"announcement"`
+		Queued       time.Time     `json:"queued"`
+		Attempts     int           `json:"attempts"`
+		Next         time.Time     `json:"next"`
+	}
+	// RetryAfter may be implemented by an error returned by a ChatAdapter, to tell an Outbox how long the chat service
+	// wants it to wait before retrying.
+	RetryAfter interface {
+		RetryAfter() time.Duration
+	}
+	retryAfterError struct {
+		err   error
+		after time.Duration
+	}
+)
+
+var (
+	// outboxCloseTimeout is how long Close waits for announcements being sent, such as a shutdown announcement.
+	outboxCloseTimeout = 5 * time.Second
+	// outboxBackoff is the delay before the first retry, doubling for each subsequent retry up to outboxMaxBackoff.
+	outboxBackoff    = 5 * time.Second
+	outboxMaxBackoff = 10 * time.Minute
+)
+
+// NewOutbox returns an Outbox queueing announcements to adapter, which logs the failures of retries to logger.
+func NewOutbox(adapter ChatAdapter, logger *Logger, opts OutboxOptions) *Outbox {
+	return &Outbox{ChatAdapter: adapter, logger: logger, opts: opts, now: time.Now}
+}
+
+// Announce queues a to be sent in the background, so a chat service that's slow or down doesn't hold up the caller.
+// Failures to send are logged and retried.
+func (o *Outbox) Announce(a *Announcement) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	err := o.load()
+	o.enqueue(a)
+	multiError(&err, o.save())
+	o.start()
+	return err
+}
+
+// AnnounceChannel queues a to be posted to a.ChanID, as Announce.
+func (o *Outbox) AnnounceChannel(a *Announcement) error {
+	return o.Announce(a)
+}
+
+// QueueDepth returns the number of announcements waiting to be sent.
+func (o *Outbox) QueueDepth() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// UpdateStatus passes s on to the ChatAdapter with the depth of this queue, if it's a StatusUpdater.
+func (o *Outbox) UpdateStatus(s *Status) error {
+	updater, ok := o.ChatAdapter.(StatusUpdater)
+	if !ok {
+		return nil
+	}
+	o.mu.Lock()
+	o.status = s
+	c := *s
+	c.QueueDepth = len(o.entries)
+	o.mu.Unlock()
+	return updater.UpdateStatus(&c)
+}
+
+// SetCommands passes commands on to the ChatAdapter, if it's a CommandSetter.
+func (o *Outbox) SetCommands(commands []*Command) {
+	if setter, ok := o.ChatAdapter.(CommandSetter); ok {
+		setter.SetCommands(commands)
+	}
+}
+
+// Listen passes through, then the first time, sends anything queued before a restart.
+func (o *Outbox) Listen() error {
+	err := o.ChatAdapter.Listen()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.loaded {
+		return err
+	}
+	if lErr := o.load(); lErr != nil {
+		o.logger.Log(lErr)
+	}
+	o.start()
+	return err
+}
+
+// Close waits up to outboxCloseTimeout for announcements being sent, then stops retrying. Anything still queued is
+// retried after a restart, except startup and shutdown announcements, which are stale by then.
+func (o *Outbox) Close() error {
+	if !o.wait(outboxCloseTimeout) {
+		o.logger.Log(NewWarn("timed out sending queued announcements"))
+	}
+	o.mu.Lock()
+	o.closed = true
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	o.mu.Unlock()
+	return o.ChatAdapter.Close()
+}
+
+// wait returns when flush isn't running, false if that takes longer than timeout.
+func (o *Outbox) wait(timeout time.Duration) bool {
+	o.mu.Lock()
+	flushed := o.flushed
+	o.mu.Unlock()
+	if flushed == nil {
+		return true
+	}
+	select {
+	case <-flushed:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// enqueue appends a to the queue. Only the newest IP address matters, so a change replaces any queued changes to the
+// same channel, in the place of the oldest, keeping its old IP address and retry schedule, or cancels them out if the
+// IP address has changed back. A collapsed change is a copy of a, which may be shared with other adapters, its message
+// rendered again.
+func (o *Outbox) enqueue(a *Announcement) {
+	if a.Kind == ChangeAnnouncement {
+		var first *outboxEntry
+		kept := o.entries[:0]
+		collapsed := 0
+		for _, e := range o.entries {
+			if e.Announcement.Kind != ChangeAnnouncement || e.Announcement.ChanID != a.ChanID || e == o.sending {
+				kept = append(kept, e)
+				continue
+			}
+			if first == nil {
+				first = e
+				kept = append(kept, e)
+			}
+			collapsed++
+		}
+		o.entries = kept
+		if first != nil {
+			o.logger.Log(Infof("collapsed %d queued ip address changes", collapsed))
+			c := *a
+			c.OldIP = first.Announcement.OldIP
+			if c.render != nil {
+				c.Message = c.render(&c)
+			}
+			first.Announcement = &c
+			first.Queued = o.now()
+			if c.OldIP == c.IP {
+				o.logger.Log(Infof("ip address changed back to %s, nothing to announce", c.IP.String()))
+				o.remove(first)
+			}
+			return
+		}
+	}
+	o.entries = append(o.entries, &outboxEntry{Announcement: a, Queued: o.now()})
+}
+
+func (o *Outbox) remove(entry *outboxEntry) {
+	for i, e := range o.entries {
+		if e == entry {
+			o.entries = append(o.entries[:i], o.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// start runs flush in the background, unless it's already running or o is closed. o.mu must be held.
+func (o *Outbox) start() {
+	if o.flushed != nil || o.closed {
+		return
+	}
+	o.flushed = make(chan struct{})
+	go o.flush()
+}
+
+// flush sends queued announcements in order until one fails, which is logged and rescheduled. o.mu is released while
+// sending, so announcements can be queued meanwhile.
+func (o *Outbox) flush() {
+	o.mu.Lock()
+	depth := len(o.entries)
+	for len(o.entries) > 0 && !o.closed {
+		e := o.entries[0]
+		if o.opts.TTL > 0 && o.now().Sub(e.Queued) >= o.opts.TTL {
+			o.logger.Log(Warnf("dropped %s announcement queued at %s after %d attempts: expired",
+				e.Announcement.Kind, formatTime(e.Queued), e.Attempts))
+			o.entries = o.entries[1:]
+			continue
+		}
+		if o.now().Before(e.Next) {
+			break
+		}
+		o.sending = e
+		o.mu.Unlock()
+		err := o.send(e.Announcement)
+		o.mu.Lock()
+		o.sending = nil
+		if err == nil {
+			o.remove(e)
+			continue
+		}
+		e.Attempts++
+		delay := retryDelay(err, e.Attempts)
+		e.Next = o.now().Add(delay)
+		o.logger.Log(WarnWrapf(err, "queued %s announcement, retrying in %s", e.Announcement.Kind, delay))
+		break
+	}
+	if err := o.save(); err != nil {
+		o.logger.Log(err)
+	}
+	o.schedule(o.now())
+	s := o.status
+	changed := len(o.entries) != depth
+	o.mu.Unlock()
+	if s != nil && changed {
+		if err := o.UpdateStatus(s); err != nil {
+			o.logger.Log(err)
+		}
+	}
+	o.mu.Lock()
+	// Anything queued while updating the status wasn't sent.
+	due := len(o.entries) > 0 && !o.now().Before(o.entries[0].Next)
+	close(o.flushed)
+	o.flushed = nil
+	if due {
+		o.start()
+	}
+	o.mu.Unlock()
+}
+
+// schedule sets the timer to retry the head of the queue.
+func (o *Outbox) schedule(now time.Time) {
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	if o.closed || len(o.entries) == 0 {
+		return
+	}
+	o.timer = time.AfterFunc(o.entries[0].Next.Sub(now), o.retry)
+}
+
+func (o *Outbox) retry() {
+	o.mu.Lock()
+	o.start()
+	o.mu.Unlock()
+}
+
+func (o *Outbox) send(a *Announcement) error {
+	if a.ChanID != "" {
+		return o.ChatAdapter.Post(a.ChanID, a.Message)
+	}
+	if announcer, ok := o.ChatAdapter.(Announcer); ok {
+		return announcer.Announce(a)
+	}
+	return o.ChatAdapter.Post("", a.Message)
+}
+
+// load reads the queue from opts.File, the first time it's needed. If it can't be read the queue starts empty.
+func (o *Outbox) load() error {
+	if o.loaded || o.opts.File == "" {
+		o.loaded = true
+		return nil
+	}
+	o.loaded = true
+	s, err := readStringFile(o.opts.File, "outbox")
+	if err != nil || s == "" {
+		return err
+	}
+	var entries []*outboxEntry
+	if err = json.Unmarshal([]byte(s), &entries); err != nil {
+		return ErrorWrapf(err, "failed to parse outbox file: %s", o.opts.File)
+	}
+	o.entries = append(entries, o.entries...)
+	return nil
+}
+
+// save writes the queue to opts.File, leaving out startup and shutdown announcements, which would be stale by the
+// time they're loaded.
+func (o *Outbox) save() error {
+	if o.opts.File == "" {
+		return nil
+	}
+	entries := make([]*outboxEntry, 0, len(o.entries))
+	for _, e := range o.entries {
+		if e.Announcement.Kind != StartupAnnouncement && e.Announcement.Kind != ShutdownAnnouncement {
+			entries = append(entries, e)
+		}
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return ErrorWrap(err, "failed to encode outbox")
+	}
+	return writeStringFile(o.opts.File, "outbox", string(b))
+}
+
+// retryDelay returns how long to wait before the next attempt after err, as asked for by the chat service, or
+// backing off exponentially.
+func retryDelay(err error, attempts int) time.Duration {
+	var ra RetryAfter
+	if errors.As(err, &ra) && ra.RetryAfter() > 0 {
+		return ra.RetryAfter()
+	}
+	var rlErr *discordgo.RateLimitError
+	if errors.As(err, &rlErr) && rlErr.RetryAfter > 0 {
+		return rlErr.RetryAfter
+	}
+	var rErr *discordgo.RESTError
+	if errors.As(err, &rErr) && rErr.Response != nil {
+		if after := parseRetryAfter(rErr.Response.Header); after > 0 {
+			return after
+		}
+	}
+	delay := outboxBackoff
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxBackoff)
+}
+
+// withRetryAfter returns err with the delay asked for by header, if any, see RetryAfter.
+func withRetryAfter(err error, header http.Header) error {
+	if after := parseRetryAfter(header); after > 0 {
+		return &retryAfterError{err: err, after: after}
+	}
+	return err
+}
+
+// parseRetryAfter returns the delay from a Retry-After header, in seconds or as a date, or Discord's
+// X-RateLimit-Reset-After header, in fractional seconds, zero if neither.
+func parseRetryAfter(header http.Header) time.Duration {
+	if s := header.Get("X-RateLimit-Reset-After"); s != "" {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return time.Duration(f * float64(time.Second))
+		}
+	}
+	s := header.Get("Retry-After")
+	if s == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+func (e *retryAfterError) RetryAfter() time.Duration {
+	return e.after
+}
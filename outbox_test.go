@@ -0,0 +1,212 @@
+package hnoss
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockAnnouncer struct {
+	mockChatAdaptor
+	announced []*Announcement
+	statuses  []*Status
+	fail      error
+}
+
+func (m *mockAnnouncer) Announce(a *Announcement) error {
+	if m.fail != nil {
+		return m.fail
+	}
+	m.announced = append(m.announced, a)
+	return nil
+}
+
+func (m *mockAnnouncer) UpdateStatus(s *Status) error {
+	m.statuses = append(m.statuses, s)
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	file := "run/outbox-test"
+	require.NoError(t, os.RemoveAll(file))
+	var buf bytes.Buffer
+	logger := &Logger{logger: log.New(&buf, "", 0)}
+	chat := &mockAnnouncer{}
+	now := newTime(t, "2023-11-28T14:00:00Z")
+	newTestOutbox := func() *Outbox {
+		o := NewOutbox(chat, logger, OutboxOptions{File: file, TTL: time.Hour})
+		o.now = func() time.Time { return now }
+		t.Cleanup(func() { _ = o.Close() })
+		return o
+	}
+	o := newTestOutbox()
+	render := func(a *Announcement) string { return a.OldIP.String() + " -> " + a.IP.String() }
+	change := func(old, new string) *Announcement {
+		a := &Announcement{Kind: ChangeAnnouncement, IP: newIP(t, new), OldIP: newIP(t, old), render: render}
+		a.Message = render(a)
+		return a
+	}
+
+	// announce waits for the background send.
+	announce := func(a *Announcement) {
+		require.NoError(t, o.Announce(a))
+		require.True(t, o.wait(time.Second))
+	}
+
+	// A failure is logged, queued and retried when the service asks.
+	chat.fail = withRetryAfter(NewError("down"), http.Header{"Retry-After": []string{"30"}})
+	announce(change("1.1.1.1", "2.2.2.2"))
+	assert.Equal(t, "WARN: queued change announcement, retrying in 30s: ERROR: down\n", buf.String())
+	assert.Equal(t, 1, o.QueueDepth())
+
+	// Changes collapse into a copy of the newest, from the oldest IP address, behind the failure.
+	newest := change("2.2.2.2", "3.3.3.3")
+	announce(newest)
+	assert.Equal(t, "2.2.2.2 -> 3.3.3.3", newest.Message)
+	announce(&Announcement{Kind: ErrorAnnouncement, Message: "failed"})
+	assert.Equal(t, 2, o.QueueDepth())
+	require.NoError(t, o.UpdateStatus(&Status{IP: newIP(t, "3.3.3.3")}))
+	assert.Equal(t, 2, chat.statuses[0].QueueDepth)
+
+	// Sent in order once due, the status is updated with the new depth.
+	chat.fail = nil
+	now = now.Add(30 * time.Second)
+	o.retry()
+	require.True(t, o.wait(time.Second))
+	require.Len(t, chat.announced, 2)
+	assert.Equal(t, "1.1.1.1 -> 3.3.3.3", chat.announced[0].Message)
+	assert.Equal(t, newIP(t, "1.1.1.1"), chat.announced[0].OldIP)
+	assert.Equal(t, "failed", chat.announced[1].Message)
+	assert.Equal(t, 0, o.QueueDepth())
+	require.Len(t, chat.statuses, 2)
+	assert.Equal(t, 0, chat.statuses[1].QueueDepth)
+
+	// Changing back cancels out.
+	chat.fail = NewError("down")
+	announce(change("3.3.3.3", "4.4.4.4"))
+	announce(change("4.4.4.4", "3.3.3.3"))
+	assert.Equal(t, 0, o.QueueDepth())
+
+	// Queued announcements survive a restart, sent on Listen, but startup and shutdown announcements don't.
+	announce(change("3.3.3.3", "5.5.5.5"))
+	announce(&Announcement{Kind: ShutdownAnnouncement, Message: "bye"})
+	assert.Equal(t, 2, o.QueueDepth())
+	o = newTestOutbox()
+	chat.fail = nil
+	now = now.Add(time.Minute)
+	require.NoError(t, o.Listen())
+	require.True(t, o.wait(time.Second))
+	require.Len(t, chat.announced, 3)
+	assert.Equal(t, "3.3.3.3 -> 5.5.5.5", chat.announced[2].Message)
+
+	// Expired announcements are dropped.
+	chat.fail = NewError("down")
+	announce(change("5.5.5.5", "6.6.6.6"))
+	chat.fail = nil
+	now = now.Add(time.Hour)
+	o.retry()
+	require.True(t, o.wait(time.Second))
+	assert.Len(t, chat.announced, 3)
+	assert.Equal(t, 0, o.QueueDepth())
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "[]", string(b))
+}
+
+func TestOutboxChannels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &Logger{logger: log.New(&buf, "", 0)}
+	chat := &mockChatAdaptor{}
+	o := NewOutbox(chat, logger, OutboxOptions{})
+	change := func(chanID, old, new string) *Announcement {
+		return &Announcement{Kind: ChangeAnnouncement, ChanID: chanID, Message: new, IP: newIP(t, new),
+			OldIP: newIP(t, old)}
+	}
+
+	// Changes only collapse into those queued for the same channel, before they're sent.
+	o.enqueue(change("c1", "1.1.1.1", "2.2.2.2"))
+	o.enqueue(change("c2", "1.1.1.1", "2.2.2.2"))
+	o.enqueue(change("", "1.1.1.1", "2.2.2.2"))
+	o.enqueue(change("c1", "2.2.2.2", "3.3.3.3"))
+	assert.Equal(t, 3, o.QueueDepth())
+	require.NoError(t, o.Listen())
+	require.True(t, o.wait(time.Second))
+	assert.Equal(t, []string{"c1: 3.3.3.3", "c2: 2.2.2.2", ": 2.2.2.2"}, chat.posts)
+
+	require.NoError(t, o.AnnounceChannel(change("c2", "2.2.2.2", "4.4.4.4")))
+	require.True(t, o.wait(time.Second))
+	assert.Equal(t, "c2: 4.4.4.4", chat.posts[3])
+	require.NoError(t, o.Close())
+}
+
+// hungAnnouncer signals started, then doesn't return from Announce until release is closed.
+type hungAnnouncer struct {
+	mockChatAdaptor
+	started, release chan struct{}
+}
+
+func (m *hungAnnouncer) Announce(*Announcement) error {
+	m.started <- struct{}{}
+	<-m.release
+	return nil
+}
+
+func TestOutboxHung(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	chat := &hungAnnouncer{started: make(chan struct{}, 2), release: make(chan struct{})}
+	o := NewOutbox(chat, logger, OutboxOptions{})
+
+	// Announcing doesn't wait for a chat service that's hung.
+	done := make(chan error)
+	go func() {
+		done <- o.Announce(&Announcement{Kind: ChangeAnnouncement, IP: newIP(t, "1.2.3.4"),
+			OldIP: newIP(t, "1.1.1.1")})
+	}()
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("announce held up by hung chat service")
+	}
+	// Announcements queued meanwhile aren't collapsed into the one being sent.
+	<-chat.started
+	require.NoError(t, o.Announce(&Announcement{Kind: ChangeAnnouncement, IP: newIP(t, "5.6.7.8"),
+		OldIP: newIP(t, "1.2.3.4")}))
+	assert.Equal(t, 2, o.QueueDepth())
+	assert.False(t, o.wait(10*time.Millisecond))
+	close(chat.release)
+	require.True(t, o.wait(time.Second))
+	assert.Equal(t, 0, o.QueueDepth())
+	require.NoError(t, o.Close())
+}
+
+func TestRetryDelay(t *testing.T) {
+	testCases := []struct {
+		description string
+		err         error
+		attempts    int
+		xDelay      time.Duration
+	}{
+		{"FirstAttempt", NewError("down"), 1, outboxBackoff},
+		{"Backoff", NewError("down"), 3, 4 * outboxBackoff},
+		{"MaxBackoff", NewError("down"), 20, outboxMaxBackoff},
+		{"RetryAfter", withRetryAfter(NewError("down"), http.Header{"Retry-After": []string{"120"}}), 1,
+			2 * time.Minute},
+		{"ResetAfter", withRetryAfter(NewError("down"), http.Header{"X-Ratelimit-Reset-After": []string{"1.5"}}), 5,
+			1500 * time.Millisecond},
+		{"Wrapped", ErrorWrap(withRetryAfter(NewError("down"), http.Header{"Retry-After": []string{"7"}}), "post"), 1,
+			7 * time.Second},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.xDelay, retryDelay(tc.err, tc.attempts))
+		})
+	}
+}
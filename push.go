@@ -0,0 +1,147 @@
+package hnoss
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// PushChatAdapter publishes announcements as push notifications to an ntfy topic or the Gotify message API.
+	// It's post-only, Chan never fires.
+	PushChatAdapter struct {
+		service   string
+		serverURL string
+		opts      PushOptions
+		c         chan *ChatEvent
+	}
+	// PushOptions configures optional PushChatAdapter behaviour.
+	PushOptions struct {
+		// Topic is the ntfy topic to publish to, required for ntfy and ignored by Gotify.
+		Topic string
+		// Token is an ntfy access token or a Gotify application token.
+		Token string
+		Title string
+		// Priorities maps an AnnouncementKind, e.g. "change" or "error", to a priority, 1-5 for ntfy or 0-10 for
+		// Gotify. Kinds without a priority use the server's default.
+		Priorities map[string]int
+		// Tags are ntfy tags or emoji shortcodes, ignored by Gotify.
+		Tags []string
+		// ClickURL is opened when the notification is tapped.
+		ClickURL string
+	}
+)
+
+const (
+	ntfyService   = "ntfy"
+	gotifyService = "gotify"
+)
+
+// NewPushChatAdapter takes service, "ntfy" or "gotify", and the server's base URL, e.g. https://ntfy.sh.
+func NewPushChatAdapter(service, serverURL string, opts PushOptions) (*PushChatAdapter, error) {
+	switch service {
+	case ntfyService:
+		if opts.Topic == "" {
+			return nil, NewFatal("config: no ntfy topic")
+		}
+	case gotifyService:
+		if opts.Token == "" {
+			return nil, NewFatal("config: no Gotify application token")
+		}
+	default:
+		return nil, Fatalf("config: unknown push service: %s", service)
+	}
+	if serverURL == "" {
+		return nil, Fatalf("config: no %s server URL", service)
+	}
+	return &PushChatAdapter{
+		service:   service,
+		serverURL: strings.TrimSuffix(serverURL, "/"),
+		opts:      opts,
+		c:         make(chan *ChatEvent),
+	}, nil
+}
+
+func (p *PushChatAdapter) Chan() <-chan *ChatEvent {
+	return p.c
+}
+
+func (p *PushChatAdapter) Listen() error {
+	return nil
+}
+
+func (p *PushChatAdapter) Close() error {
+	return nil
+}
+
+// Post msg as a notification, chanID is ignored.
+func (p *PushChatAdapter) Post(_, msg string) error {
+	hostname, _ := os.Hostname()
+	return p.Announce(&Announcement{Message: msg, Time: time.Now().UTC(), Hostname: hostname})
+}
+
+func (p *PushChatAdapter) Announce(a *Announcement) error {
+	priority, hasPriority := p.opts.Priorities[string(a.Kind)]
+	var url string
+	body := map[string]any{"message": a.Message}
+	if p.opts.Title != "" {
+		body["title"] = p.opts.Title
+	}
+	if p.service == ntfyService {
+		url = p.serverURL
+		body["topic"] = p.opts.Topic
+		if hasPriority {
+			body["priority"] = priority
+		}
+		if len(p.opts.Tags) > 0 {
+			body["tags"] = p.opts.Tags
+		}
+		if p.opts.ClickURL != "" {
+			body["click"] = p.opts.ClickURL
+		}
+	} else {
+		url = p.serverURL + "/message"
+		if hasPriority {
+			body["priority"] = priority
+		}
+		if p.opts.ClickURL != "" {
+			body["extras"] = map[string]any{
+				"client::notification": map[string]any{"click": map[string]string{"url": p.opts.ClickURL}},
+			}
+		}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return ErrorWrapf(err, "failed to encode %s message", p.service)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return ErrorWrapf(err, "failed to create %s request", p.service)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.opts.Token != "" {
+		if p.service == ntfyService {
+			req.Header.Set("Authorization", "Bearer "+p.opts.Token)
+		} else {
+			req.Header.Set("X-Gotify-Key", p.opts.Token)
+		}
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return ErrorWrapf(err, "failed to publish %s message", p.service)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		// Both services describe the error in a JSON "error" field.
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&e)
+		return withRetryAfter(Errorf("%s publish failed: %s: %s", p.service, res.Status, e.Error), res.Header)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package hnoss
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushChatAdapter(t *testing.T) {
+	var paths []string
+	var headers []http.Header
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		headers = append(headers, r.Header)
+		var body map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies = append(bodies, body)
+		if body["message"] == "fail" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":"forbidden"}`))
+		}
+	}))
+	defer server.Close()
+
+	opts := PushOptions{
+		Topic:      "hnoss",
+		Token:      "token",
+		Title:      "hnoss",
+		Priorities: map[string]int{"change": 3, "error": 5},
+		Tags:       []string{"globe_with_meridians"},
+		ClickURL:   "https://example.org",
+	}
+	p, err := NewPushChatAdapter("ntfy", server.URL+"/", opts)
+	require.NoError(t, err)
+	assert.NoError(t, p.Listen())
+	require.NoError(t, p.Announce(&Announcement{Kind: ErrorAnnouncement, Message: "failed"}))
+	require.NoError(t, p.Post("", "1.2.3.4"))
+
+	g, err := NewPushChatAdapter("gotify", server.URL, opts)
+	require.NoError(t, err)
+	require.NoError(t, g.Announce(&Announcement{Kind: ChangeAnnouncement, Message: "1.2.3.4"}))
+	err = g.Post("", "fail")
+	assert.EqualError(t, err, "ERROR: gotify publish failed: 403 Forbidden: forbidden")
+
+	require.Len(t, bodies, 4)
+	assert.Equal(t, "/", paths[0])
+	assert.Equal(t, "Bearer token", headers[0].Get("Authorization"))
+	assert.Equal(t, map[string]any{
+		"topic":    "hnoss",
+		"title":    "hnoss",
+		"message":  "failed",
+		"priority": 5.0,
+		"tags":     []any{"globe_with_meridians"},
+		"click":    "https://example.org",
+	}, bodies[0])
+	// Plain posts have no kind, so no priority.
+	assert.NotContains(t, bodies[1], "priority")
+
+	assert.Equal(t, "/message", paths[2])
+	assert.Equal(t, "token", headers[2].Get("X-Gotify-Key"))
+	assert.Equal(t, map[string]any{
+		"title":    "hnoss",
+		"message":  "1.2.3.4",
+		"priority": 3.0,
+		"extras": map[string]any{
+			"client::notification": map[string]any{"click": map[string]any{"url": "https://example.org"}},
+		},
+	}, bodies[2])
+
+	var fatal *Fatal
+	_, err = NewPushChatAdapter("ntfy", server.URL, PushOptions{})
+	assert.ErrorAs(t, err, &fatal)
+	_, err = NewPushChatAdapter("pushover", server.URL, opts)
+	assert.ErrorAs(t, err, &fatal)
+}
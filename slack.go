@@ -0,0 +1,206 @@
+package hnoss
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+type (
+	// SlackChatAdapter receives mentions over Slack Socket Mode and posts with the Web API.
+	SlackChatAdapter struct {
+		appToken       string
+		botToken       string
+		defaultChannel string
+		api            string
+
+		botUserID string
+		c         chan *ChatEvent
+		conn      *websocket.Conn
+		mu        sync.Mutex
+	}
+	slackResponse struct {
+		OK     bool   `json:"ok"`
+		Error  string `json:"error"`
+		URL    string `json:"url"`
+		UserID string `json:"user_id"`
+	}
+	slackEnvelope struct {
+		EnvelopeID string `json:"envelope_id"`
+		Type       string `json:"type"`
+		Payload    struct {
+			TeamID string `json:"team_id"`
+			Event  struct {
+				Type    string `json:"type"`
+				User    string `json:"user"`
+				Text    string `json:"text"`
+				Channel string `json:"channel"`
+				TS      string `json:"ts"`
+			} `json:"event"`
+		} `json:"payload"`
+	}
+)
+
+const slackAPI = "https://slack.com/api/"
+
+// NewSlackChatAdapter takes an app-level token with the connections:write scope, used for Socket Mode, and a
+// bot token with the app_mentions:read and chat:write scopes.
+func NewSlackChatAdapter(appToken, botToken, defaultChannel string) *SlackChatAdapter {
+	return &SlackChatAdapter{
+		appToken:       appToken,
+		botToken:       botToken,
+		defaultChannel: defaultChannel,
+		api:            slackAPI,
+		c:              make(chan *ChatEvent),
+	}
+}
+
+func (s *SlackChatAdapter) Chan() <-chan *ChatEvent {
+	return s.c
+}
+
+func (s *SlackChatAdapter) Listen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		return NewWarn("Slack already connected")
+	}
+
+	res, err := s.call("auth.test", s.botToken, nil)
+	if err != nil {
+		return err
+	}
+	s.botUserID = res.UserID
+	res, err = s.call("apps.connections.open", s.appToken, nil)
+	if err != nil {
+		return err
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(res.URL, nil)
+	if err != nil {
+		return ErrorWrap(err, "failed to open Slack Socket Mode connection")
+	}
+	var hello slackEnvelope
+	if err = conn.ReadJSON(&hello); err != nil {
+		_ = conn.Close()
+		return ErrorWrap(err, "failed to receive Slack hello")
+	}
+	if hello.Type != "hello" {
+		_ = conn.Close()
+		return Errorf("expected Slack hello, got: %s", hello.Type)
+	}
+	s.conn = conn
+	go s.read(conn)
+	return NewInfo("connected to Slack")
+}
+
+// Read envelopes from conn until it is closed, either by Close or by Slack.
+func (s *SlackChatAdapter) read(conn *websocket.Conn) {
+	defer s.disconnect(conn)
+	for {
+		var env slackEnvelope
+		if err := conn.ReadJSON(&env); err != nil {
+			return
+		}
+		switch env.Type {
+		case "disconnect":
+			// Slack is about to close the connection, Listen will reconnect.
+			return
+		case "events_api":
+			if err := conn.WriteJSON(map[string]string{"envelope_id": env.EnvelopeID}); err != nil {
+				return
+			}
+			if env.Payload.Event.Type == "app_mention" {
+				s.c <- s.newChatEvent(&env)
+			}
+		}
+	}
+}
+
+func (s *SlackChatAdapter) disconnect(conn *websocket.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = conn.Close()
+	if s.conn == conn {
+		s.conn = nil
+	}
+}
+
+func (s *SlackChatAdapter) newChatEvent(env *slackEnvelope) *ChatEvent {
+	e := env.Payload.Event
+	ev := &ChatEvent{
+		ChanID:     e.Channel,
+		GuildID:    env.Payload.TeamID,
+		AuthorID:   e.User,
+		AuthorName: e.User,
+		Text:       e.Text,
+		Reply: func(msg string) error {
+			return s.post(e.Channel, msg, e.TS)
+		},
+	}
+	ev.Command, ev.Args = ParseCommand(strings.ReplaceAll(e.Text, "<@"+s.botUserID+">", ""))
+	return ev
+}
+
+func (s *SlackChatAdapter) Close() error {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	if err := conn.Close(); err != nil {
+		return ErrorWrap(err, "failed to close Slack connection")
+	}
+	return nil
+}
+
+func (s *SlackChatAdapter) Post(chanID, msg string) error {
+	if chanID == "" {
+		chanID = s.defaultChannel
+	}
+	return s.post(chanID, msg, "")
+}
+
+// post msg to channel, in the thread of message threadTS if not empty.
+func (s *SlackChatAdapter) post(channel, msg, threadTS string) error {
+	body := map[string]string{"channel": channel, "text": msg}
+	if threadTS != "" {
+		body["thread_ts"] = threadTS
+	}
+	_, err := s.call("chat.postMessage", s.botToken, body)
+	return err
+}
+
+// call Slack Web API method with token, sending body as JSON.
+func (s *SlackChatAdapter) call(method, token string, body any) (*slackResponse, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return nil, ErrorWrapf(err, "failed to encode Slack %s request", method)
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, s.api+method, bytes.NewReader(b))
+	if err != nil {
+		return nil, ErrorWrapf(err, "failed to create Slack %s request", method)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, ErrorWrapf(err, "failed to call Slack %s", method)
+	}
+	defer res.Body.Close()
+	sr := &slackResponse{}
+	if err = json.NewDecoder(res.Body).Decode(sr); err != nil {
+		return nil, ErrorWrapf(err, "failed to decode Slack %s response", method)
+	}
+	if !sr.OK {
+		return nil, Errorf("Slack %s failed: %s", method, sr.Error)
+	}
+	return sr, nil
+}
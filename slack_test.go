@@ -0,0 +1,93 @@
+package hnoss
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlackChatAdapter(t *testing.T) {
+	acks := make(chan string, 1)
+	posts := make(chan map[string]string, 1)
+	var conn *websocket.Conn
+	connected := make(chan struct{})
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/auth.test":
+			assert.Equal(t, "Bearer xoxb", r.Header.Get("Authorization"))
+			_, _ = w.Write([]byte(`{"ok":true,"user_id":"UBOT"}`))
+		case "/api/apps.connections.open":
+			assert.Equal(t, "Bearer xapp", r.Header.Get("Authorization"))
+			u := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+			_, _ = w.Write([]byte(`{"ok":true,"url":"` + u + `"}`))
+		case "/api/chat.postMessage":
+			assert.Equal(t, "Bearer xoxb", r.Header.Get("Authorization"))
+			var body map[string]string
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			posts <- body
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		case "/ws":
+			var err error
+			conn, err = (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			require.NoError(t, err)
+			require.NoError(t, conn.WriteJSON(map[string]string{"type": "hello"}))
+			close(connected)
+			var ack map[string]string
+			if conn.ReadJSON(&ack) == nil {
+				acks <- ack["envelope_id"]
+			}
+		default:
+			_, _ = w.Write([]byte(`{"ok":false,"error":"unknown_method"}`))
+		}
+	}))
+	defer server.Close()
+
+	s := NewSlackChatAdapter("xapp", "xoxb", "#general")
+	s.api = server.URL + "/api/"
+
+	err := s.Listen()
+	var info *Info
+	assert.ErrorAs(t, err, &info)
+	<-connected
+	err = s.Listen()
+	var warn *Warn
+	assert.ErrorAs(t, err, &warn)
+
+	require.NoError(t, conn.WriteJSON(map[string]any{
+		"envelope_id": "env1",
+		"type":        "events_api",
+		"payload": map[string]any{
+			"team_id": "T1",
+			"event": map[string]string{
+				"type":    "app_mention",
+				"user":    "U1",
+				"text":    "<@UBOT> history 2",
+				"channel": "C1",
+				"ts":      "1.2",
+			},
+		},
+	}))
+	ev := <-s.Chan()
+	assert.Equal(t, "env1", <-acks)
+	assert.Equal(t, "C1", ev.ChanID)
+	assert.Equal(t, "T1", ev.GuildID)
+	assert.Equal(t, "U1", ev.AuthorID)
+	assert.Equal(t, "history", ev.Command)
+	assert.Equal(t, []string{"2"}, ev.Args)
+
+	require.NoError(t, ev.Reply("1.2.3.4"))
+	assert.Equal(t, map[string]string{"channel": "C1", "text": "1.2.3.4", "thread_ts": "1.2"}, <-posts)
+
+	require.NoError(t, s.Post("", "5.6.7.8"))
+	assert.Equal(t, map[string]string{"channel": "#general", "text": "5.6.7.8"}, <-posts)
+
+	assert.NoError(t, s.Close())
+}
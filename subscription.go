@@ -0,0 +1,394 @@
+package hnoss
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type (
+	// Subscription configures the announcements sent to a channel, in addition to those sent to the default channel.
+	Subscription struct {
+		// ChanID identifies the channel as seen by Hnoss, see ChatEvent.ChanID.
+		ChanID string             `json:"chanID"`
+		Kinds  []AnnouncementKind `json:"kinds"`
+		// Families limits change announcements, and the changes listed in digests, to IP addresses of the families
+		// "ipv4" and "ipv6", all if empty.
+		Families []string `json:"families,omitempty"`
+		// Template formats announcements to the channel instead of their default message, see MessageData.
+		Template string `json:"template,omitempty"`
+		tmpl     *template.Template
+	}
+	// SubscriptionAdapter should persist channel subscriptions.
+	SubscriptionAdapter interface {
+		Get() ([]*Subscription, error)
+		Put([]*Subscription) error
+	}
+)
+
+const (
+	// DigestAnnouncement lists the IP address changes of the last day. It's only sent to subscribed channels, by the
+	// first scheduled run of each day in the time zone of Config.Offset.
+	DigestAnnouncement AnnouncementKind = "digest"
+)
+
+const (
+	subscribeCommand     = "subscribe"
+	unsubscribeCommand   = "unsubscribe"
+	formatCommand        = "format"
+	subscriptionsCommand = "subscriptions"
+	ipv4Family           = "ipv4"
+	ipv6Family           = "ipv6"
+	// maxDigestChanges limits the changes listed in a digest.
+	maxDigestChanges = 50
+)
+
+// subscriptionKinds are the kinds of announcement a channel may subscribe to. Startup and shutdown announcements are
+// only sent to channels with a template, as they have no default message.
+var subscriptionKinds = []AnnouncementKind{ChangeAnnouncement, ErrorAnnouncement, RecoveredAnnouncement,
+	StartupAnnouncement, ShutdownAnnouncement, DigestAnnouncement}
+
+var addressFamilies = []string{ipv4Family, ipv6Family}
+
+// subscriptionCommands are registered by New if it has a SubscriptionAdapter, only administrators may use them, see
+// Config.AdminUsers.
+var subscriptionCommands = []*Command{
+	{Name: subscribeCommand, Usage: "[event|family...]", Description: "Subscribe this channel to events, any of " +
+		"change, error, recovered, startup, shutdown and digest, change if none, only for IP addresses of the " +
+		"families ipv4 or ipv6, if given", Handler: adminOnly((*Hnoss).subscribe)},
+	{Name: unsubscribeCommand, Description: "Unsubscribe this channel", Handler: adminOnly((*Hnoss).unsubscribe)},
+	{Name: formatCommand, Usage: "[template]", Description: "Set the message template of this channel, or clear it " +
+		"if none", Handler: adminOnly((*Hnoss).format)},
+	{Name: subscriptionsCommand, Description: "List subscribed channels", Handler: adminOnly((*Hnoss).listSubscriptions)},
+}
+
+// adminOnly wraps handler to refuse anyone but an administrator.
+func adminOnly(handler CommandHandler) CommandHandler {
+	return func(h *Hnoss, ev *ChatEvent) error {
+		if !h.config.isAdmin(ev) {
+			h.logger.Log(Warnf("denied %q request from %s: not an administrator", ev.Text, describeEvent(ev)))
+			return h.Reply(ev, h.tr(ev, msgNotAdmin))
+		}
+		return handler(h, ev)
+	}
+}
+
+// subscribe replaces the subscription of the channel of ev with the events and families in ev.Args, keeping its
+// template.
+func (h *Hnoss) subscribe(ev *ChatEvent) error {
+	s := &Subscription{ChanID: ev.ChanID}
+	// Args may be a single string, from a Discord slash command option.
+	for _, arg := range strings.Fields(strings.ToLower(strings.Join(ev.Args, " "))) {
+		kind := AnnouncementKind(arg)
+		switch {
+		case containsKind(subscriptionKinds, kind):
+			if !containsKind(s.Kinds, kind) {
+				s.Kinds = append(s.Kinds, kind)
+			}
+		case contains(addressFamilies, arg):
+			if !contains(s.Families, arg) {
+				s.Families = append(s.Families, arg)
+			}
+		default:
+			return h.Reply(ev, h.tr(ev, msgSubscribeInvalid, arg))
+		}
+	}
+	if len(s.Kinds) == 0 {
+		s.Kinds = []AnnouncementKind{ChangeAnnouncement}
+	}
+	subs, err := h.getSubscriptions()
+	if err != nil {
+		return err
+	}
+	if old := findSubscription(subs, ev.ChanID); old != nil {
+		s.Template, s.tmpl = old.Template, old.tmpl
+	}
+	if err = h.putSubscriptions(replaceSubscription(subs, ev.ChanID, s)); err != nil {
+		return err
+	}
+	h.logger.Log(Infof("subscribed channel %s to %s", ev.ChanID, s.describe()))
+	return h.Reply(ev, h.tr(ev, msgSubscribed, s.describe()))
+}
+
+func (h *Hnoss) unsubscribe(ev *ChatEvent) error {
+	subs, err := h.getSubscriptions()
+	if err != nil {
+		return err
+	}
+	if findSubscription(subs, ev.ChanID) == nil {
+		return h.Reply(ev, h.tr(ev, msgNotSubscribed, subscribeCommand))
+	}
+	if err = h.putSubscriptions(replaceSubscription(subs, ev.ChanID, nil)); err != nil {
+		return err
+	}
+	h.logger.Log(Infof("unsubscribed channel %s", ev.ChanID))
+	return h.Reply(ev, h.tr(ev, msgUnsubscribed))
+}
+
+// format sets the template of the channel of ev to ev.Args, joined by spaces.
+func (h *Hnoss) format(ev *ChatEvent) error {
+	subs, err := h.getSubscriptions()
+	if err != nil {
+		return err
+	}
+	old := findSubscription(subs, ev.ChanID)
+	if old == nil {
+		return h.Reply(ev, h.tr(ev, msgNotSubscribed, subscribeCommand))
+	}
+	s := *old
+	s.Template, s.tmpl = strings.Join(ev.Args, " "), nil
+	if s.Template != "" {
+		if s.tmpl, err = parseMessageTemplate("channel", s.Template, ChangeAnnouncement); err != nil {
+			return h.Reply(ev, h.tr(ev, msgFormatInvalid, errorMessage(err)))
+		}
+	}
+	if err = h.putSubscriptions(replaceSubscription(subs, ev.ChanID, &s)); err != nil {
+		return err
+	}
+	h.logger.Log(Infof("set message template of channel %s to %q", ev.ChanID, s.Template))
+	if s.Template == "" {
+		return h.Reply(ev, h.tr(ev, msgFormatCleared))
+	}
+	return h.Reply(ev, h.tr(ev, msgFormatSet))
+}
+
+func (h *Hnoss) listSubscriptions(ev *ChatEvent) error {
+	h.logger.Log(Infof("replying to subscriptions request from %s", describeEvent(ev)))
+	subs, err := h.getSubscriptions()
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return h.Reply(ev, h.tr(ev, msgSubscriptionsNone))
+	}
+	var b strings.Builder
+	b.WriteString(h.tr(ev, msgSubscriptions))
+	for _, s := range subs {
+		fmt.Fprintf(&b, "\n%s: %s", s.ChanID, s.describe())
+		if s.Template != "" {
+			fmt.Fprintf(&b, " %q", s.Template)
+		}
+	}
+	return h.Reply(ev, b.String())
+}
+
+// getSubscriptions returns the subscriptions, loading them the first time, none without a SubscriptionAdapter.
+func (h *Hnoss) getSubscriptions() ([]*Subscription, error) {
+	if h.subscriptionAdapter == nil || h.subscriptionsLoaded {
+		return h.subscriptions, nil
+	}
+	subs, err := h.subscriptionAdapter.Get()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range subs {
+		if s.Template == "" {
+			continue
+		}
+		// A template that no longer parses is ignored, rather than losing the subscription.
+		if s.tmpl, err = parseMessageTemplate("channel", s.Template, ChangeAnnouncement); err != nil {
+			h.logger.Log(WarnWrapf(err, "ignoring message template of channel %s", s.ChanID))
+		}
+	}
+	sortSubscriptions(subs)
+	h.subscriptions = subs
+	h.subscriptionsLoaded = true
+	return subs, nil
+}
+
+// putSubscriptions persists subs, only replacing the current subscriptions if that succeeds.
+func (h *Hnoss) putSubscriptions(subs []*Subscription) error {
+	sortSubscriptions(subs)
+	if err := h.subscriptionAdapter.Put(subs); err != nil {
+		return err
+	}
+	h.subscriptions = subs
+	return nil
+}
+
+// subscribed returns whether any channel is subscribed to kind.
+func (h *Hnoss) subscribed(kind AnnouncementKind) bool {
+	subs, err := h.getSubscriptions()
+	if err != nil {
+		h.logger.Log(err)
+		return false
+	}
+	for _, s := range subs {
+		if containsKind(s.Kinds, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify announces a to every channel subscribed to its kind, errMsg and changes as for MessageData, see
+// subscriptionMessage. Channels are announced to independently, a failure is logged.
+func (h *Hnoss) notify(a *Announcement, errMsg string, changes []*Change) {
+	subs, err := h.getSubscriptions()
+	if err != nil {
+		h.logger.Log(err)
+		return
+	}
+	for _, s := range subs {
+		if !containsKind(s.Kinds, a.Kind) || (a.Kind == ChangeAnnouncement && !s.hasFamily(a.IP)) {
+			continue
+		}
+		s := s
+		cs := changes
+		if a.Kind == DigestAnnouncement {
+			cs = s.filterChanges(changes)
+		}
+		c := *a
+		c.ChanID = s.ChanID
+		c.render = func(c *Announcement) string { return h.subscriptionMessage(s, c, errMsg, cs) }
+		if c.Message = c.render(&c); c.Message == "" {
+			continue
+		}
+		if err = h.announceChannel(&c); err != nil {
+			h.logger.Log(err)
+		}
+	}
+}
+
+// subscriptionMessage renders the message of a to the channel of s, with the channel's template if it has one,
+// otherwise the default in the channel's locale.
+func (h *Hnoss) subscriptionMessage(s *Subscription, a *Announcement, errMsg string, changes []*Change) string {
+	if s.tmpl != nil {
+		var b strings.Builder
+		err := s.tmpl.Execute(&b, h.messageData(a, errMsg, changes))
+		if err == nil {
+			return b.String()
+		}
+		h.logger.Log(ErrorWrapf(err, "failed to execute message template of channel %s", s.ChanID))
+	}
+	ev := &ChatEvent{ChanID: s.ChanID}
+	if a.Kind == DigestAnnouncement {
+		return h.digestMessage(ev, a.IP, changes)
+	}
+	return h.message(ev, a, errMsg)
+}
+
+// digest notifies channels subscribed to digests of the last day's changes, if one hasn't been sent on the day of the
+// scheduled run at t. If one has never been sent, only the first run of a day sends one.
+func (h *Hnoss) digest(t time.Time) {
+	if !h.subscribed(DigestAnnouncement) {
+		return
+	}
+	last := h.getDigested()
+	if last.Equal(zeroTime) {
+		last = t.Add(-h.config.Interval)
+	}
+	loc := h.config.Offset.Location()
+	y, m, d := t.In(loc).Date()
+	py, pm, pd := last.In(loc).Date()
+	if y == py && m == pm && d == pd {
+		return
+	}
+	changes, err := h.historyAdapter.List(maxDigestChanges)
+	if err != nil {
+		h.logger.Log(err)
+		return
+	}
+	since := t.Add(-24 * time.Hour)
+	n := 0
+	for n < len(changes) && changes[n].Time.After(since) {
+		n++
+	}
+	h.logger.Log(Infof("sending digest of %d changes", n))
+	a := &Announcement{Kind: DigestAnnouncement, IP: h.ip, Time: t}
+	a.Hostname, _ = os.Hostname()
+	h.notify(a, "", changes[:n])
+	h.digested = t
+	if h.digestAdapter != nil {
+		if err = h.digestAdapter.Put(t); err != nil {
+			h.logger.Log(err)
+		}
+	}
+}
+
+// getDigested returns when the last digest was sent, zero if never or if that can't be read, which is logged.
+func (h *Hnoss) getDigested() time.Time {
+	if !h.digestedLoaded && h.digestAdapter != nil {
+		var err error
+		if h.digested, err = h.digestAdapter.Get(); err != nil {
+			h.logger.Log(err)
+		}
+	}
+	h.digestedLoaded = true
+	return h.digested
+}
+
+// digestMessage is the default message of a digest to the channel of ev.
+func (h *Hnoss) digestMessage(ev *ChatEvent, ip netip.Addr, changes []*Change) string {
+	if len(changes) == 0 {
+		return h.tr(ev, msgDigestNone, addrString(ip))
+	}
+	return h.tr(ev, msgDigest, addrString(ip)) + formatHistory(changes)
+}
+
+// hasFamily returns whether ip is of one of the families of s.
+func (s *Subscription) hasFamily(ip netip.Addr) bool {
+	if len(s.Families) == 0 {
+		return true
+	}
+	if ip.Unmap().Is4() {
+		return contains(s.Families, ipv4Family)
+	}
+	return ip.Is6() && contains(s.Families, ipv6Family)
+}
+
+// filterChanges returns those of changes to an IP address of one of the families of s.
+func (s *Subscription) filterChanges(changes []*Change) []*Change {
+	if len(s.Families) == 0 {
+		return changes
+	}
+	var filtered []*Change
+	for _, c := range changes {
+		if s.hasFamily(c.New) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// describe lists the kinds and families of s, as given to the subscribe command.
+func (s *Subscription) describe() string {
+	words := make([]string, 0, len(s.Kinds)+len(s.Families))
+	for _, kind := range s.Kinds {
+		words = append(words, string(kind))
+	}
+	return strings.Join(append(words, s.Families...), " ")
+}
+
+func findSubscription(subs []*Subscription, chanID string) *Subscription {
+	for _, s := range subs {
+		if s.ChanID == chanID {
+			return s
+		}
+	}
+	return nil
+}
+
+// replaceSubscription returns a copy of subs with the subscription of chanID replaced by s, or removed if s is nil.
+func replaceSubscription(subs []*Subscription, chanID string, s *Subscription) []*Subscription {
+	replaced := make([]*Subscription, 0, len(subs)+1)
+	for _, sub := range subs {
+		if sub.ChanID != chanID {
+			replaced = append(replaced, sub)
+		}
+	}
+	if s != nil {
+		replaced = append(replaced, s)
+	}
+	return replaced
+}
+
+func sortSubscriptions(subs []*Subscription) {
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].ChanID < subs[j].ChanID
+	})
+}
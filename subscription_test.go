@@ -0,0 +1,119 @@
+package hnoss
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionCommands(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "subscriptions")
+	conf := &Config{IPMessageFormat: "%s", AdminUsers: []string{"admin"}, AdminRoles: []string{"mods"}}
+	newHnoss := func() *Hnoss {
+		return New(conf, logger, &mockTimeAdaptor{}, &mockIPAdaptor{}, &mockIPAdaptor{}, &mockHistoryAdaptor{},
+			NewJSONFileSubscriptionAdapter(file), nil, &mockChatAdaptor{}, nil)
+	}
+	h := newHnoss()
+
+	var reply string
+	request := func(chanID, user, text string, roles ...string) string {
+		ev := &ChatEvent{ChanID: chanID, AuthorID: user, RoleIDs: roles, Text: text, Reply: func(msg string) error {
+			reply = msg
+			return nil
+		}}
+		ev.Command, ev.Args = ParseCommand(text)
+		h.command(ev)
+		return reply
+	}
+
+	assert.Equal(t, "sorry, only administrators can change subscriptions", request("1", "user", "subscribe"))
+	assert.Equal(t, "no channels are subscribed", request("1", "user", "subscriptions", "mods"))
+	assert.Equal(t, "this channel isn't subscribed, try subscribe", request("1", "admin", "format {{.IP}}"))
+	assert.Equal(t, "unknown event or address family: changed", request("1", "admin", "subscribe changed"))
+	assert.Equal(t, "this channel is subscribed to: change", request("2", "admin", "subscribe"))
+	// A single argument, as from a Discord slash command, is split too.
+	ev := &ChatEvent{ChanID: "1", AuthorID: "admin", Command: subscribeCommand, Args: []string{"Digest error ipv6"},
+		Reply: func(msg string) error {
+			reply = msg
+			return nil
+		}}
+	h.command(ev)
+	assert.Equal(t, "this channel is subscribed to: digest error ipv6", reply)
+	assert.Equal(t, "couldn't set message template: failed to parse channel message template: template: "+
+		"channel:1: unclosed action", request("1", "admin", "format {{.IP"))
+	assert.Equal(t, "message template set", request("1", "admin", "format {{.Kind}}: {{.IP}}"))
+	// Resubscribing keeps the template.
+	request("1", "admin", "subscribe digest error")
+
+	// Subscriptions are persisted.
+	h = newHnoss()
+	assert.Equal(t, "subscriptions:\n1: digest error \"{{.Kind}}: {{.IP}}\"\n2: change",
+		request("1", "admin", "subscriptions"))
+	assert.Equal(t, "message template cleared", request("1", "admin", "format"))
+	assert.Equal(t, "this channel is unsubscribed", request("2", "admin", "unsubscribe"))
+	assert.Equal(t, "this channel isn't subscribed, try subscribe", request("2", "admin", "unsubscribe"))
+	h = newHnoss()
+	assert.Equal(t, "subscriptions:\n1: digest error", request("1", "admin", "subscriptions"))
+}
+
+func TestNotify(t *testing.T) {
+	logger, err := NewLogger("")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	subscriptions := NewJSONFileSubscriptionAdapter(filepath.Join(dir, "subscriptions"))
+	digest := NewTextFileTimeAdapter(filepath.Join(dir, "digest"))
+	require.NoError(t, subscriptions.Put([]*Subscription{
+		{ChanID: "all", Kinds: []AnnouncementKind{ChangeAnnouncement, ErrorAnnouncement, DigestAnnouncement}},
+		{ChanID: "v6", Kinds: []AnnouncementKind{ChangeAnnouncement, DigestAnnouncement}, Families: []string{"ipv6"},
+			Template: "{{.IPv6}}{{range .Changes}} {{.New}}{{end}}"},
+		{ChanID: "startup", Kinds: []AnnouncementKind{StartupAnnouncement}, Template: "{{.Kind}}"},
+	}))
+	conf := &Config{IPMessageFormat: "%s:2456", Interval: 6 * time.Hour, Offset: newTime(t, "2023-11-28T00:00:00Z")}
+	ipService := &mockIPAdaptor{ip: newIP(t, "1.2.3.4")}
+	history := &mockHistoryAdaptor{}
+	chat := &mockChatAdaptor{}
+	newHnoss := func() *Hnoss {
+		return New(conf, logger, &mockTimeAdaptor{}, ipService, &mockIPAdaptor{}, history, subscriptions, digest, chat,
+			nil)
+	}
+	h := newHnoss()
+
+	// Errors go to subscribed channels even if they aren't announced to the default channel.
+	h.announceEvent(newTime(t, "2023-11-28T00:00:00Z"), StartupAnnouncement)
+	ipService.err = NewError("An error")
+	h.run(newTime(t, "2023-11-28T06:00:00Z"), false, nil)
+	ipService.err = nil
+	h.run(newTime(t, "2023-11-28T12:00:00Z"), false, nil)
+	ipService.ip = newIP(t, "2001:db8::1")
+	h.run(newTime(t, "2023-11-28T18:00:00Z"), false, nil)
+	// Only the first run of the day sends a digest, of the changes of the last 24 hours.
+	h.run(newTime(t, "2023-11-29T00:00:00Z"), false, nil)
+	h.run(newTime(t, "2023-11-29T06:00:00Z"), false, nil)
+
+	assert.Equal(t, []string{
+		"startup: startup",
+		"all: failed to get ip address: An error",
+		": 1.2.3.4:2456",
+		"all: 1.2.3.4:2456",
+		": 2001:db8::1:2456",
+		"all: 2001:db8::1:2456",
+		"v6: 2001:db8::1",
+		"all: ip address: 2001:db8::1\nchanges in the last day:\n" +
+			"2023-11-28T18:00:00Z 1.2.3.4 -> 2001:db8::1 (mockIPAdaptor)\n" +
+			"2023-11-28T12:00:00Z - -> 1.2.3.4 (mockIPAdaptor)",
+		"v6: 2001:db8::1 2001:db8::1",
+	}, chat.posts)
+
+	// A restart doesn't send the day's digest again, but sends one missed by the first run of a day.
+	chat.posts = nil
+	h = newHnoss()
+	h.digest(newTime(t, "2023-11-29T00:00:00Z"))
+	assert.Empty(t, chat.posts)
+	h.digest(newTime(t, "2023-11-30T09:00:00Z"))
+	assert.Equal(t, []string{"all: ip address: -\nno changes in the last day"}, chat.posts)
+}
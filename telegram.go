@@ -0,0 +1,322 @@
+package hnoss
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// TelegramChatAdapter receives commands and mentions with Telegram Bot API getUpdates long polling, or an
+	// optional webhook listener, and posts with sendMessage.
+	TelegramChatAdapter struct {
+		token         string
+		defaultChatID string
+		opts          TelegramOptions
+		api           string
+
+		username string
+		offset   int64
+		listener net.Listener
+		c        chan *ChatEvent
+		cancel   context.CancelFunc
+		done     chan struct{}
+		mu       sync.Mutex
+	}
+	// TelegramOptions configures optional TelegramChatAdapter behaviour.
+	TelegramOptions struct {
+		// WebhookURL, if set, is registered with Telegram and updates are received by listening on WebhookListen,
+		// e.g. behind a TLS terminating reverse proxy, instead of by polling.
+		WebhookURL    string
+		WebhookListen string
+		// WebhookSecret is sent by Telegram with every update to prove the update came from Telegram. It's required
+		// with WebhookURL.
+		WebhookSecret string
+	}
+	telegramResponse struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	telegramUpdate struct {
+		UpdateID int64            `json:"update_id"`
+		Message  *telegramMessage `json:"message"`
+	}
+	telegramMessage struct {
+		MessageID int64 `json:"message_id"`
+		From      *struct {
+			ID        int64  `json:"id"`
+			Username  string `json:"username"`
+			FirstName string `json:"first_name"`
+		} `json:"from"`
+		Chat struct {
+			ID   int64  `json:"id"`
+			Type string `json:"type"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	}
+)
+
+const (
+	telegramAPI          = "https://api.telegram.org"
+	telegramPollTimeout  = 30 * time.Second
+	telegramRetryBackoff = 5 * time.Second
+)
+
+func NewTelegramChatAdapter(token, defaultChatID string, opts TelegramOptions) *TelegramChatAdapter {
+	return &TelegramChatAdapter{
+		token:         token,
+		defaultChatID: defaultChatID,
+		opts:          opts,
+		api:           telegramAPI,
+		c:             make(chan *ChatEvent),
+	}
+}
+
+func (t *TelegramChatAdapter) Chan() <-chan *ChatEvent {
+	return t.c
+}
+
+func (t *TelegramChatAdapter) Listen() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		return NewWarn("Telegram already listening")
+	}
+	// Without a secret anyone who finds the webhook could send commands as any user.
+	if t.opts.WebhookURL != "" && t.opts.WebhookSecret == "" {
+		return NewFatal("Telegram webhook requires a secret")
+	}
+
+	var me struct {
+		Username string `json:"username"`
+	}
+	if err := t.call(context.Background(), "getMe", nil, &me); err != nil {
+		return err
+	}
+	t.username = me.Username
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	if t.opts.WebhookURL != "" {
+		l, err := net.Listen("tcp", t.opts.WebhookListen)
+		if err != nil {
+			cancel()
+			return ErrorWrapf(err, "failed to listen for Telegram webhook on: %s", t.opts.WebhookListen)
+		}
+		err = t.call(ctx, "setWebhook", map[string]any{
+			"url":             t.opts.WebhookURL,
+			"secret_token":    t.opts.WebhookSecret,
+			"allowed_updates": []string{"message"},
+		}, nil)
+		if err != nil {
+			cancel()
+			_ = l.Close()
+			return err
+		}
+		t.listener = l
+		go t.serve(ctx, l, done)
+	} else {
+		// getUpdates fails while a webhook is set.
+		if err := t.call(ctx, "deleteWebhook", nil, nil); err != nil {
+			cancel()
+			return err
+		}
+		go t.poll(ctx, done)
+	}
+	t.cancel = cancel
+	t.done = done
+	return NewInfo("connected to Telegram")
+}
+
+// Long poll getUpdates until ctx is cancelled.
+func (t *TelegramChatAdapter) poll(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	for {
+		var updates []*telegramUpdate
+		err := t.call(ctx, "getUpdates", map[string]any{
+			"offset":          t.offset,
+			"timeout":         int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"message"},
+		}, &updates)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			select {
+			case <-time.After(telegramRetryBackoff):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		for _, u := range updates {
+			t.offset = u.UpdateID + 1
+			if !t.update(ctx, u) {
+				return
+			}
+		}
+	}
+}
+
+// Serve webhook updates on l until ctx is cancelled.
+func (t *TelegramChatAdapter) serve(ctx context.Context, l net.Listener, done chan struct{}) {
+	defer close(done)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || subtle.ConstantTimeCompare(
+			[]byte(r.Header.Get("X-Telegram-Bot-Api-Secret-Token")), []byte(t.opts.WebhookSecret)) != 1 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		u := &telegramUpdate{}
+		if err := json.NewDecoder(r.Body).Decode(u); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		t.update(ctx, u)
+	})}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	_ = server.Serve(l)
+}
+
+// Send a ChatEvent if u is a request of the bot, returns false if ctx was cancelled.
+func (t *TelegramChatAdapter) update(ctx context.Context, u *telegramUpdate) bool {
+	m := u.Message
+	if m == nil || m.Text == "" {
+		return true
+	}
+	text, ok := t.request(m)
+	if !ok {
+		return true
+	}
+	chatID := strconv.FormatInt(m.Chat.ID, 10)
+	ev := &ChatEvent{
+		ChanID: chatID,
+		Text:   m.Text,
+		Reply: func(msg string) error {
+			return t.send(chatID, msg, m.MessageID)
+		},
+	}
+	if m.From != nil {
+		// A user's private chat ID is their user ID, the bot can only message users who have started it.
+		userID := strconv.FormatInt(m.From.ID, 10)
+		ev.ReplyDirect = func(msg string) error {
+			return t.send(userID, msg, 0)
+		}
+		ev.AuthorID = userID
+		ev.AuthorName = m.From.Username
+		if ev.AuthorName == "" {
+			ev.AuthorName = m.From.FirstName
+		}
+	}
+	ev.Command, ev.Args = ParseCommand(text)
+	select {
+	case t.c <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// request returns the text of m with any command slash or bot mention removed, and whether m is a request of
+// the bot: a command, a mention or any message in a private chat.
+func (t *TelegramChatAdapter) request(m *telegramMessage) (string, bool) {
+	mention := "@" + t.username
+	if strings.HasPrefix(m.Text, "/") {
+		cmd, rest, _ := strings.Cut(m.Text[1:], " ")
+		// Commands may be addressed to a particular bot, e.g. /ip@hnoss_bot.
+		cmd, bot, addressed := strings.Cut(cmd, "@")
+		if addressed && !strings.EqualFold(bot, t.username) {
+			return "", false
+		}
+		return cmd + " " + rest, true
+	}
+	var fields []string
+	mentioned := false
+	for _, f := range strings.Fields(m.Text) {
+		if strings.EqualFold(strings.TrimRight(f, ":,"), mention) {
+			mentioned = true
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return strings.Join(fields, " "), mentioned || m.Chat.Type == "private"
+}
+
+func (t *TelegramChatAdapter) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel == nil {
+		return nil
+	}
+	t.cancel()
+	<-t.done
+	t.cancel = nil
+	t.listener = nil
+	return nil
+}
+
+func (t *TelegramChatAdapter) Post(chanID, msg string) error {
+	if chanID == "" {
+		chanID = t.defaultChatID
+	}
+	return t.send(chanID, msg, 0)
+}
+
+// send msg to chatID, in reply to message replyTo if not 0.
+func (t *TelegramChatAdapter) send(chatID, msg string, replyTo int64) error {
+	body := map[string]any{"chat_id": chatID, "text": msg}
+	if replyTo != 0 {
+		body["reply_to_message_id"] = replyTo
+	}
+	return t.call(context.Background(), "sendMessage", body, nil)
+}
+
+// call Bot API method, sending body as JSON and decoding the result into res, if not nil.
+func (t *TelegramChatAdapter) call(ctx context.Context, method string, body, res any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return ErrorWrapf(err, "failed to encode Telegram %s request", method)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.api+"/bot"+t.token+"/"+method, bytes.NewReader(b))
+	if err != nil {
+		return ErrorWrapf(err, "failed to create Telegram %s request", method)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// Don't leak the token in the URL.
+		var uErr *url.Error
+		if errors.As(err, &uErr) {
+			err = uErr.Err
+		}
+		return ErrorWrapf(err, "failed to call Telegram %s", method)
+	}
+	defer resp.Body.Close()
+	tr := &telegramResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(tr); err != nil {
+		return ErrorWrapf(err, "failed to decode Telegram %s response", method)
+	}
+	if !tr.OK {
+		return Errorf("Telegram %s failed: %s", method, tr.Description)
+	}
+	if res == nil {
+		return nil
+	}
+	if err = json.Unmarshal(tr.Result, res); err != nil {
+		return ErrorWrapf(err, "failed to decode Telegram %s result", method)
+	}
+	return nil
+}
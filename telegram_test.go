@@ -0,0 +1,123 @@
+package hnoss
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeTelegramAPI stands in for the Bot API, sending each update on updates in reply to getUpdates and each
+// request body on calls.
+func newFakeTelegramAPI(t *testing.T, updates chan string, calls chan map[string]any) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, ok := strings.CutPrefix(r.URL.Path, "/bottoken/")
+		assert.True(t, ok)
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body == nil {
+			body = map[string]any{}
+		}
+		switch method {
+		case "getMe":
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"username":"hnoss_bot"}}`))
+			return
+		case "getUpdates":
+			select {
+			case u := <-updates:
+				_, _ = w.Write([]byte(`{"ok":true,"result":[` + u + `]}`))
+			case <-r.Context().Done():
+			}
+			return
+		}
+		body["method"] = method
+		calls <- body
+		_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTelegramChatAdapter(t *testing.T) {
+	updates := make(chan string)
+	calls := make(chan map[string]any, 10)
+	server := newFakeTelegramAPI(t, updates, calls)
+
+	tg := NewTelegramChatAdapter("token", "-100", TelegramOptions{})
+	tg.api = server.URL
+	var info *Info
+	assert.ErrorAs(t, tg.Listen(), &info)
+	assert.Equal(t, "deleteWebhook", (<-calls)["method"])
+	var warn *Warn
+	assert.ErrorAs(t, tg.Listen(), &warn)
+
+	updates <- `{"update_id":1,"message":{"message_id":10,"from":{"id":5,"username":"user"},
+		"chat":{"id":-100,"type":"group"},"text":"/history@hnoss_bot 2"}}`
+	ev := <-tg.Chan()
+	assert.Equal(t, "-100", ev.ChanID)
+	assert.Equal(t, "5", ev.AuthorID)
+	assert.Equal(t, "user", ev.AuthorName)
+	assert.Equal(t, "history", ev.Command)
+	assert.Equal(t, []string{"2"}, ev.Args)
+
+	updates <- `{"update_id":2,"message":{"message_id":11,"chat":{"id":-100,"type":"group"},"text":"/ip@other_bot"}},
+		{"update_id":3,"message":{"message_id":12,"chat":{"id":-100,"type":"group"},"text":"no mention"}},
+		{"update_id":4,"message":{"message_id":13,"chat":{"id":-100,"type":"group"},"text":"@Hnoss_Bot ip"}}`
+	ev = <-tg.Chan()
+	assert.Equal(t, "ip", ev.Command)
+	assert.Equal(t, int64(5), tg.offset)
+
+	require.NoError(t, ev.Reply("1.2.3.4"))
+	assert.Equal(t, map[string]any{"method": "sendMessage", "chat_id": "-100", "text": "1.2.3.4",
+		"reply_to_message_id": 13.0}, <-calls)
+	require.NoError(t, tg.Post("", "5.6.7.8"))
+	assert.Equal(t, map[string]any{"method": "sendMessage", "chat_id": "-100", "text": "5.6.7.8"}, <-calls)
+
+	require.NoError(t, tg.Close())
+}
+
+func TestTelegramChatAdapterWebhook(t *testing.T) {
+	calls := make(chan map[string]any, 10)
+	server := newFakeTelegramAPI(t, nil, calls)
+
+	tg := NewTelegramChatAdapter("token", "-100", TelegramOptions{
+		WebhookURL:    "https://example.org/hnoss",
+		WebhookListen: "127.0.0.1:0",
+	})
+	tg.api = server.URL
+	var fatal *Fatal
+	assert.ErrorAs(t, tg.Listen(), &fatal)
+
+	tg.opts.WebhookSecret = "secret"
+	var info *Info
+	require.ErrorAs(t, tg.Listen(), &info)
+	call := <-calls
+	assert.Equal(t, "setWebhook", call["method"])
+	assert.Equal(t, "https://example.org/hnoss", call["url"])
+	assert.Equal(t, "secret", call["secret_token"])
+
+	hook := "http://" + tg.listener.Addr().String()
+	update := []byte(`{"update_id":1,"message":{"message_id":10,"chat":{"id":5,"type":"private"},"text":"status"}}`)
+	res, err := http.Post(hook, "application/json", bytes.NewReader(update))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, hook, bytes.NewReader(update))
+		require.NoError(t, err)
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "secret")
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}()
+	ev := <-tg.Chan()
+	assert.Equal(t, "5", ev.ChanID)
+	assert.Equal(t, "status", ev.Command)
+
+	require.NoError(t, tg.Close())
+}
@@ -0,0 +1,135 @@
+package hnoss
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"text/template"
+	"time"
+)
+
+type (
+	// WebhookChatAdapter sends an HTTP request for every announcement. It's post-only, Chan never fires.
+	WebhookChatAdapter struct {
+		url  string
+		opts WebhookOptions
+		body *template.Template
+		c    chan *ChatEvent
+	}
+	// WebhookOptions configures optional WebhookChatAdapter behaviour.
+	WebhookOptions struct {
+		// Method defaults to POST.
+		Method  string
+		Headers map[string]string
+		// BodyTemplate is a text/template executed with an Announcement, defaults to defaultWebhookBody. The json
+		// function encodes its argument as JSON.
+		BodyTemplate string
+		// Secret, if set, signs the body with HMAC-SHA256, sent as "sha256=<hex>" in the X-Hnoss-Signature header.
+		Secret string
+		// Retries is the number of times to retry a request that fails with a network error, 429 or 5xx status.
+		Retries int
+	}
+)
+
+const defaultWebhookBody = `{"message":{{json .Message}},"ip":{{json .IP}},"oldIP":{{json .OldIP}},` +
+	`"time":{{json .Time}},"hostname":{{json .Hostname}}}`
+
+// webhookRetryDelay is the delay before the first retry, doubled for each subsequent retry.
+var webhookRetryDelay = time.Second
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func NewWebhookChatAdapter(url string, opts WebhookOptions) (*WebhookChatAdapter, error) {
+	if opts.Method == "" {
+		opts.Method = http.MethodPost
+	}
+	if opts.BodyTemplate == "" {
+		opts.BodyTemplate = defaultWebhookBody
+	}
+	body, err := template.New("webhook").Funcs(webhookFuncs).Parse(opts.BodyTemplate)
+	if err != nil {
+		return nil, FatalWrap(err, "config: failed to parse webhook body template")
+	}
+	return &WebhookChatAdapter{
+		url:  url,
+		opts: opts,
+		body: body,
+		c:    make(chan *ChatEvent),
+	}, nil
+}
+
+func (w *WebhookChatAdapter) Chan() <-chan *ChatEvent {
+	return w.c
+}
+
+func (w *WebhookChatAdapter) Listen() error {
+	return nil
+}
+
+func (w *WebhookChatAdapter) Close() error {
+	return nil
+}
+
+// Post sends msg alone, the webhook has no channels so chanID is ignored.
+func (w *WebhookChatAdapter) Post(_, msg string) error {
+	hostname, _ := os.Hostname()
+	return w.Announce(&Announcement{Message: msg, Time: time.Now().UTC(), Hostname: hostname})
+}
+
+func (w *WebhookChatAdapter) Announce(a *Announcement) error {
+	var body bytes.Buffer
+	if err := w.body.Execute(&body, a); err != nil {
+		return ErrorWrap(err, "failed to execute webhook body template")
+	}
+	var signature string
+	if w.opts.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.opts.Secret))
+		mac.Write(body.Bytes())
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	delay := webhookRetryDelay
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = w.send(body.Bytes(), signature); err == nil || !retry || attempt == w.opts.Retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// send body, returning whether a failed request should be retried.
+func (w *WebhookChatAdapter) send(body []byte, signature string) (bool, error) {
+	req, err := http.NewRequest(w.opts.Method, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, ErrorWrap(err, "failed to create webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	if signature != "" {
+		req.Header.Set("X-Hnoss-Signature", signature)
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return true, ErrorWrap(err, "failed to send webhook request")
+	}
+	_ = res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return retry, withRetryAfter(Errorf("webhook request failed: %s", res.Status), res.Header)
+}
@@ -0,0 +1,74 @@
+package hnoss
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookChatAdapter(t *testing.T) {
+	defer func(d time.Duration) {
+		webhookRetryDelay = d
+	}(webhookRetryDelay)
+	webhookRetryDelay = time.Millisecond
+
+	var bodies []string
+	var headers []http.Header
+	statuses := []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		b, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		bodies = append(bodies, string(b))
+		headers = append(headers, r.Header)
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+	defer server.Close()
+
+	w, err := NewWebhookChatAdapter(server.URL, WebhookOptions{
+		Method:  http.MethodPut,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Secret:  "secret",
+		Retries: 1,
+	})
+	require.NoError(t, err)
+	assert.NoError(t, w.Listen())
+
+	ti, err := time.Parse(time.RFC3339, "2023-11-28T00:00:00Z")
+	require.NoError(t, err)
+	err = w.Announce(&Announcement{
+		Message:  "5.6.7.8",
+		IP:       netip.MustParseAddr("5.6.7.8"),
+		OldIP:    netip.MustParseAddr("1.2.3.4"),
+		Time:     ti,
+		Hostname: "host",
+	})
+	require.NoError(t, err)
+	require.Len(t, bodies, 2)
+	assert.Equal(t, bodies[0], bodies[1])
+	assert.JSONEq(t, `{"message":"5.6.7.8","ip":"5.6.7.8","oldIP":"1.2.3.4","time":"2023-11-28T00:00:00Z",
+		"hostname":"host"}`, bodies[1])
+	assert.Equal(t, "Bearer token", headers[1].Get("Authorization"))
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(bodies[1]))
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), headers[1].Get("X-Hnoss-Signature"))
+
+	// Client errors aren't retried.
+	assert.Error(t, w.Post("", "1.2.3.4"))
+	assert.Len(t, bodies, 3)
+	assert.NoError(t, w.Close())
+
+	_, err = NewWebhookChatAdapter(server.URL, WebhookOptions{BodyTemplate: "{{"})
+	var fatal *Fatal
+	assert.ErrorAs(t, err, &fatal)
+}